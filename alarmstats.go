@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type AlarmPeriod struct {
+	Start  time.Time `json:"start"`
+	Alarms int       `json:"alarms"`
+}
+
+// handleAlarmStats totals alarms per ?interval=day|week (default day) over
+// start_date/end_date (default the past 30 days), optionally for one
+// gate_name. Alarms are sparse, so ?only_alarms=true drops the periods with
+// none to leave just the clusters.
+func (app *App) handleAlarmStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	interval := q.Get("interval")
+	switch interval {
+	case "":
+		interval = "day"
+	case "day", "week":
+	default:
+		writeError(w, http.StatusBadRequest, `interval must be "day" or "week"`)
+		return
+	}
+	onlyAlarms, _ := strconv.ParseBool(q.Get("only_alarms"))
+
+	start, end, err := app.rangeParams(r, 30, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where := " WHERE timestamp >= ? AND timestamp < ?"
+	args := []any{start, end}
+	if gateName := q.Get("gate_name"); gateName != "" {
+		where += " AND gate_name = ?"
+		args = append(args, gateName)
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0)
+		FROM `+app.table+where+filter+`
+		GROUP BY timestamp
+	`, append(args, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	buckets := map[time.Time]int{}
+	for rows.Next() {
+		var ts time.Time
+		var alarms int
+		if err := rows.Scan(&ts, &alarms); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		buckets[bucketStart(ts.In(time.Local), interval)] += alarms
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := app.now()
+	periods := []AlarmPeriod{}
+	total := 0
+	for b := bucketStart(start, interval); b.Before(end) && !b.After(now); b = nextBucket(b, interval) {
+		total += buckets[b]
+		if onlyAlarms && buckets[b] == 0 {
+			continue
+		}
+		periods = append(periods, AlarmPeriod{Start: b, Alarms: buckets[b]})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"interval":     interval,
+		"total_alarms": total,
+		"data":         periods,
+	})
+}
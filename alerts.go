@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Alert is an event an operator should act on. Alerts are always logged at
+// error level and, if ALERT_WEBHOOK_URL is set, posted there as JSON.
+type Alert struct {
+	Kind     string    `json:"kind"`
+	GateName string    `json:"gate_name,omitempty"`
+	Message  string    `json:"message"`
+	Detail   string    `json:"detail,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+func (app *App) alert(a Alert) {
+	a.Time = app.now()
+	slog.Error("ALERT "+a.Message, "kind", a.Kind, "gate", a.GateName, "detail", a.Detail)
+
+	if app.alertWebhookURL == "" {
+		return
+	}
+	// Deliver in the background so a slow webhook never delays polling
+	go func() {
+		if err := app.postAlert(a); err != nil {
+			slog.Warn("Failed to deliver alert webhook", "kind", a.Kind, "error", err)
+		}
+	}()
+}
+
+func (app *App) postAlert(a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, app.alertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// influxLookbackRows is how many readings at the hour checkInflux reads per
+// reading it needs, leaving room for those on closures or outside open hours.
+const influxLookbackRows = 4
+
+// checkInflux alerts when a reading's entrances exceed INFLUX_ALERT_MULTIPLIER
+// times the gate's average over its previous INFLUX_ALERT_WINDOW readings at
+// the same hour of day, so facilities hear about a surge while it's happening
+// without the morning rush counting against a quiet night. Readings on closure
+// days or outside configured OPEN_HOURS are left out of the baseline. Small
+// polls are ignored below INFLUX_ALERT_MIN_ENTRANCES since a quiet gate's
+// average is near zero, and a gate alerts at most once per
+// INFLUX_ALERT_COOLDOWN.
+func (app *App) checkInflux(ctx context.Context, gc GateCount) {
+	if gc.IncomingDiff < max(app.influxMinEntrances, 1) {
+		return
+	}
+	now := app.now()
+	app.influxMu.Lock()
+	last, alerted := app.influxAlerted[gc.GateName]
+	app.influxMu.Unlock()
+	if alerted && now.Sub(last) < app.influxCooldown {
+		return
+	}
+
+	// Bounding the lookback to a week per reading keeps the scan on the index
+	// rather than the gate's whole history, and the limit leaves room for the
+	// closed hours skipped below.
+	local := gc.Timestamp.In(time.Local)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, incoming_diff
+		FROM `+app.table+`
+		WHERE gate_name = ? AND timestamp >= ? AND timestamp < ? AND failed = 0 AND suspect = 0
+		AND HOUR(`+app.localTimestamp()+`) = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`, gc.GateName, gc.Timestamp.AddDate(0, 0, -7*app.influxWindow), gc.Timestamp, local.Hour(), influxLookbackRows*app.influxWindow)
+	if err != nil {
+		slog.Warn("Failed to load recent entrances for influx check", "gate", gc.GateName, "error", err)
+		return
+	}
+	defer rows.Close()
+
+	total, readings := 0, 0
+	for readings < app.influxWindow && rows.Next() {
+		var ts time.Time
+		var in int
+		if err := rows.Scan(&ts, &in); err != nil {
+			slog.Warn("Failed to load recent entrances for influx check", "gate", gc.GateName, "error", err)
+			return
+		}
+		ts = ts.In(time.Local)
+		if app.closures.contains(ts) || (app.openHours[ts.Weekday()].Start >= 0 && !app.openHours.isOpen(ts)) {
+			continue
+		}
+		total += max(in, 0)
+		readings++
+	}
+	if err := rows.Err(); err != nil {
+		slog.Warn("Failed to load recent entrances for influx check", "gate", gc.GateName, "error", err)
+		return
+	}
+
+	average := float64(total) / float64(max(readings, 1))
+	// Without a full window there's no baseline to compare against yet
+	if readings < app.influxWindow || float64(gc.IncomingDiff) <= average*app.influxMultiplier {
+		return
+	}
+
+	app.influxMu.Lock()
+	if app.influxAlerted == nil {
+		app.influxAlerted = map[string]time.Time{}
+	}
+	app.influxAlerted[gc.GateName] = now
+	app.influxMu.Unlock()
+	app.alert(Alert{
+		Kind:     "influx",
+		GateName: gc.GateName,
+		Message:  "Unusual influx at " + gc.GateName,
+		Detail:   fmt.Sprintf("%d entrances in the latest poll against an average of %.1f over the last %d at %02d:00", gc.IncomingDiff, average, readings, local.Hour()),
+	})
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+)
+
+func getAdminToken() string {
+	if data, err := os.ReadFile("/var/run/secrets/OLE_ADMIN_TOKEN"); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// requireAdmin only lets requests through that present the configured admin
+// token as a bearer token. Admin endpoints are disabled when no token is set.
+func (app *App) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.adminToken == "" {
+			writeError(w, http.StatusForbidden, "admin endpoints are disabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ole-gate-count"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next(w, r)
+	}
+}
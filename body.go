@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBodyMiddleware caps every request body at MAX_REQUEST_BODY_BYTES so a
+// huge upload can't exhaust memory while a handler decodes it.
+func (app *App) maxBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.maxBodyBytes > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, app.maxBodyBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bodyTooLarge answers 413 and returns true if err came from reading past
+// the body limit, so decode failures can fall back to their usual 400.
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", maxErr.Limit))
+	return true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// gateSource is one gate the poller reads, with the building it belongs to
+// and its position within that building's URL list.
+type gateSource struct {
+	URL      string
+	Building string
+	Index    int
+}
+
+// validBuildingName keeps building names short, fitting the building column,
+// and free of the "/" that separates them from the gate in a gate name.
+var validBuildingName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 .-]{0,63}$`)
+
+// parseGateSources reads the gates to poll. BUILDINGS lists several buildings
+// as "Name=url1,url2;Other=url3"; each building's gates are recorded under
+// "Name/<gate>" so one deployment can serve them all from a shared table.
+// Without it, OLE_GATE_URLS is a single building named by GATE_PREFIX, which
+// can only be filtered on if it is a valid building name.
+func parseGateSources(buildings, gateURLs, prefix string) ([]gateSource, []string, error) {
+	if strings.TrimSpace(buildings) == "" {
+		var sources []gateSource
+		for i, url := range splitList(gateURLs) {
+			sources = append(sources, gateSource{URL: url, Building: prefix, Index: i})
+		}
+		var names []string
+		if validBuildingName.MatchString(prefix) {
+			names = []string{prefix}
+		}
+		return sources, names, nil
+	}
+
+	var sources []gateSource
+	var names []string
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(buildings, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, urls, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validBuildingName.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid building %q: expected Name=url1,url2", entry)
+		}
+		if seen[name] {
+			return nil, nil, fmt.Errorf("building %q is listed twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+		for i, url := range splitList(urls) {
+			sources = append(sources, gateSource{URL: url, Building: name, Index: i})
+		}
+	}
+	return sources, names, nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func (app *App) hasBuilding(name string) bool {
+	for _, b := range app.buildings {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildingFilter returns the condition limiting a stats query to the
+// building named by the building query parameter, and its argument.
+func buildingFilter(r *http.Request) (string, []any) {
+	if b := r.URL.Query().Get("building"); b != "" {
+		return " AND building = ?", []any{b}
+	}
+	return "", nil
+}
+
+// buildingOf returns the configured building a "Building/gate" name was
+// recorded under, or "" for names outside every building.
+func (app *App) buildingOf(gateName string) string {
+	if b, _, ok := strings.Cut(gateName, "/"); ok && app.hasBuilding(b) {
+		return b
+	}
+	return ""
+}
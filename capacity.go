@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+type CapacityPoint struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Occupancy    int       `json:"occupancy"`
+	Percent      float64   `json:"percent"`
+	OverCapacity bool      `json:"over_capacity"`
+}
+
+// handleCapacitySeries returns the hourly occupancy of a day as a percentage
+// of BUILDING_CAPACITY. It takes the same date and tz parameters as
+// /occupancy_series and shares its caveats: occupancy starts from zero at
+// midnight, ignores counter resets and is clamped at zero, so sensor
+// imbalance tends to understate it late in the day. The percentage is
+// clamped to 0-100; over_capacity marks hours where the count went past the
+// limit.
+func (app *App) handleCapacitySeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if app.buildingCapacity <= 0 {
+		writeError(w, http.StatusNotFound, "building capacity is not configured")
+		return
+	}
+
+	loc, err := tzParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	day, err := app.dayParam(r, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	occupancy, err := app.occupancySeries(ctx, day, filter, filterArgs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series := make([]CapacityPoint, 0, len(occupancy))
+	for _, p := range occupancy {
+		pct := float64(p.Occupancy) / float64(app.buildingCapacity) * 100
+		series = append(series, CapacityPoint{
+			Timestamp:    p.Timestamp,
+			Occupancy:    p.Occupancy,
+			Percent:      app.round(min(pct, 100)),
+			OverCapacity: p.Occupancy > app.buildingCapacity,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"date":     day.Format(dateLayout),
+		"tz":       loc.String(),
+		"capacity": app.buildingCapacity,
+		"data":     series,
+	})
+}
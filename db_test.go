@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"testing"
+)
+
+// fakeResult is what a fakeDB query answers with.
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeDB is a database/sql driver that answers queries from a function and
+// records the statements it executes, for handlers whose SQL can't run here.
+type fakeDB struct {
+	mu    sync.Mutex
+	query func(query string, args []driver.NamedValue) (fakeResult, error)
+	exec  func(query string, args []driver.NamedValue)
+	execs []fakeStatement
+}
+
+type fakeStatement struct {
+	query string
+	args  []driver.Value
+}
+
+// open returns a *sql.DB backed by d, closed when the test ends.
+func (d *fakeDB) open(t *testing.T) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(d)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func (d *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{d: d}, nil }
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) { return d.Open("") }
+func (d *fakeDB) Driver() driver.Driver                        { return d }
+
+type fakeConn struct{ d *fakeDB }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res := fakeResult{}
+	if c.d.query != nil {
+		var err error
+		if res, err = c.d.query(query, args); err != nil {
+			return nil, err
+		}
+	}
+	return &fakeRows{result: res}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values := make([]driver.Value, len(args))
+	for i, a := range args {
+		values[i] = a.Value
+	}
+	c.d.mu.Lock()
+	c.d.execs = append(c.d.execs, fakeStatement{query: query, args: values})
+	n := len(c.d.execs)
+	c.d.mu.Unlock()
+	if c.d.exec != nil {
+		c.d.exec(query, args)
+	}
+	return fakeExecResult(n), nil
+}
+
+// fakeExecResult numbers inserts by the statement's position in execs.
+type fakeExecResult int64
+
+func (r fakeExecResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (r fakeExecResult) RowsAffected() (int64, error) { return 1, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
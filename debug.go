@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof exposes the runtime profiling handlers behind admin auth.
+// pprof.Index resolves named profiles relative to /debug/pprof/, so these
+// live at the root rather than under SCRIPT_NAME.
+func (app *App) registerPprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", app.requireAdmin(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", app.requireAdmin(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", app.requireAdmin(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", app.requireAdmin(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", app.requireAdmin(pprof.Trace))
+}
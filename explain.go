@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// handleQueryExplain returns the SQL and bound parameters /query would run
+// for the same request body, without running it, so client developers can
+// check which filters actually arrive. The parameters are shown after
+// validation and defaulting, exactly as they would be bound.
+func (app *App) handleQueryExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req QueryFilter
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if err := app.validateFilter(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query, args := app.gateCountsQuery(req, true)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"filter":  req,
+		"sql":     query,
+		"args":    args,
+	})
+}
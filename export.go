@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// writeCSV streams the readings matching the filters as a CSV attachment.
+func (app *App) writeCSV(ctx context.Context, w http.ResponseWriter, filter QueryFilter) {
+	truncated, err := app.exceedsExportCap(ctx, filter)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	where, args := filter.Build()
+	latest, err := app.latestReading(ctx, where, args)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	rows, err := app.queryGateCountRows(ctx, filter, false)
+	if err != nil {
+		http.Error(w, "Query failed", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	filename := app.csvFilename(filter)
+	setLastModified(w, latest)
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	// Gate names come from the gate devices and config, so quote fields with
+	// encoding/csv rather than trusting them to be free of commas and quotes.
+	cw := csv.NewWriter(w)
+	writeRow := func(fields ...string) error {
+		if err := cw.Write(fields); err != nil {
+			return err
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	if err := writeRow("timestamp", "gate_name", "alarm_count", "alarm_diff", "incoming_patrons_count", "incoming_diff", "outgoing_patrons_count", "outgoing_diff"); err != nil {
+		slog.Error("Failed to write CSV header", "error", err)
+		return
+	}
+
+	// Stream CSV data straight from the cursor. If the client goes away the
+	// request context is cancelled, which stops rows.Next and the DB work.
+	for rows.Next() {
+		record, err := scanGateCount(rows)
+		if err != nil {
+			slog.Error("Failed to scan gate count", "error", err)
+			return
+		}
+		if err := writeRow(
+			record.Timestamp.Format("2006-01-02 15:04:05"),
+			record.GateName,
+			strconv.Itoa(record.AlarmCount),
+			strconv.Itoa(record.AlarmDiff),
+			strconv.Itoa(record.IncomingPatronsCount),
+			strconv.Itoa(record.IncomingDiff),
+			strconv.Itoa(record.OutgoingPatronsCount),
+			strconv.Itoa(record.OutgoingDiff),
+		); err != nil {
+			slog.Error("Failed to write CSV line", "error", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("CSV export aborted", "error", err)
+	}
+}
+
+// writeJSONL streams the readings matching the filters as one JSON object per
+// line so large pulls can be consumed incrementally.
+func (app *App) writeJSONL(ctx context.Context, w http.ResponseWriter, filter QueryFilter) {
+	truncated, err := app.exceedsExportCap(ctx, filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	where, args := filter.Build()
+	latest, err := app.latestReading(ctx, where, args)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	rows, err := app.queryGateCountRows(ctx, filter, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	setLastModified(w, latest)
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for rows.Next() {
+		record, err := scanGateCount(rows)
+		if err != nil {
+			slog.Error("Failed to scan gate count", "error", err)
+			return
+		}
+		if err := enc.Encode(record); err != nil {
+			slog.Error("Failed to write JSON line", "error", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		slog.Error("JSONL export aborted", "error", err)
+	}
+}
+
+// unsafeFilenameChars matches anything that isn't safe in a filename on
+// common filesystems.
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// csvFilename fills the CSV_FILENAME template for an export. {gate}, {start}
+// and {end} come from the filter ("all" when unset) and {timestamp} is the
+// time of the export.
+func (app *App) csvFilename(filter QueryFilter) string {
+	orAll := func(v string) string {
+		if v == "" {
+			return "all"
+		}
+		return v
+	}
+	name := strings.NewReplacer(
+		"{gate}", orAll(filter.GateName),
+		"{start}", orAll(filter.StartDate),
+		"{end}", orAll(filter.EndDate),
+		"{timestamp}", app.now().Format("20060102_150405"),
+	).Replace(app.csvFilenameTemplate)
+	return unsafeFilenameChars.ReplaceAllString(name, "_")
+}
+
+func (app *App) writeMonthlyStatsCSV(w http.ResponseWriter, metric string, stats []MonthlyStats) {
+	filename := fmt.Sprintf("monthly_stats_%s.csv", app.now().Format("20060102_150405"))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+
+	columns := map[string][]string{
+		"entrances":       {"entrances"},
+		"entrances,exits": {"entrances", "exits"},
+		"exits":           {"exits"},
+		"both":            {"entrances", "exits", "total"},
+		"net":             {"entrances", "exits", "net"},
+	}[metric]
+
+	if _, err := fmt.Fprintf(w, "month,%s\n", strings.Join(columns, ",")); err != nil {
+		slog.Error("Failed to write CSV header", "error", err)
+		return
+	}
+	for _, stat := range stats {
+		fields := map[string]*int{
+			"entrances": stat.Entrances,
+			"exits":     stat.Exits,
+			"total":     stat.Total,
+			"net":       stat.Net,
+		}
+		line := stat.Month
+		for _, c := range columns {
+			line += "," + strconv.Itoa(*fields[c])
+		}
+		if _, err := w.Write([]byte(line + "\n")); err != nil {
+			slog.Error("Failed to write CSV line", "error", err)
+			return
+		}
+	}
+}
+
+// prefersCSV reports whether an Accept header ranks text/csv above
+// application/json. JSON wins ties and is the default for wildcards.
+func prefersCSV(accept string) bool {
+	csvQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch mediaType {
+		case "text/csv":
+			csvQ = max(csvQ, q)
+		case "application/json", "*/*":
+			jsonQ = max(jsonQ, q)
+		}
+	}
+	return csvQ > 0 && csvQ > jsonQ
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// streamDriver is a database/sql driver whose gate count query returns an
+// endless cursor, so a test can see whether an export stops on its own.
+type streamDriver struct {
+	mu       sync.Mutex
+	queryCtx context.Context
+	served   atomic.Int64
+	closed   chan struct{}
+}
+
+func (d *streamDriver) Open(string) (driver.Conn, error) { return &streamConn{d: d}, nil }
+
+func (d *streamDriver) Connect(context.Context) (driver.Conn, error) { return d.Open("") }
+func (d *streamDriver) Driver() driver.Driver                        { return d }
+
+type streamConn struct{ d *streamDriver }
+
+func (c *streamConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c *streamConn) Close() error                        { return nil }
+func (c *streamConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (c *streamConn) QueryContext(ctx context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	if strings.Contains(query, "MAX(timestamp)") {
+		return &latestRows{}, nil
+	}
+	c.d.mu.Lock()
+	c.d.queryCtx = ctx
+	c.d.mu.Unlock()
+	return &endlessRows{d: c.d}, nil
+}
+
+// latestRows answers latestReading with a single NULL.
+type latestRows struct{ done bool }
+
+func (r *latestRows) Columns() []string { return []string{"MAX(timestamp)"} }
+func (r *latestRows) Close() error      { return nil }
+func (r *latestRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = nil
+	return nil
+}
+
+type endlessRows struct {
+	d    *streamDriver
+	once sync.Once
+}
+
+func (r *endlessRows) Columns() []string { return strings.Split(gateCountColumns, ", ") }
+
+func (r *endlessRows) Close() error {
+	r.once.Do(func() { close(r.d.closed) })
+	return nil
+}
+
+// Next paces rows like a network read would, giving database/sql's
+// cancellation watcher a chance to close the cursor between them.
+func (r *endlessRows) Next(dest []driver.Value) error {
+	time.Sleep(time.Millisecond)
+	n := r.d.served.Add(1)
+	values := []driver.Value{n, time.Now(), "Gate 1", "", n, int64(1), n, int64(1), n, int64(1), false, false, statusOK}
+	copy(dest, values)
+	return nil
+}
+
+// cancellingWriter cancels the request once a few lines have been written,
+// as a client disconnecting mid-download would.
+type cancellingWriter struct {
+	*httptest.ResponseRecorder
+	lines  int
+	after  int
+	cancel context.CancelFunc
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.lines++
+	if w.lines == w.after {
+		w.cancel()
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestWriteCSVStopsWhenContextCancelled(t *testing.T) {
+	d := &streamDriver{closed: make(chan struct{})}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	app := &App{db: db, table: "lib_gate_counts", now: time.Now, csvFilenameTemplate: "export.csv"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w := &cancellingWriter{ResponseRecorder: httptest.NewRecorder(), after: 5, cancel: cancel}
+
+	finished := make(chan struct{})
+	go func() {
+		app.writeCSV(ctx, w, QueryFilter{})
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("export kept streaming after the request context was cancelled")
+	}
+
+	select {
+	case <-d.closed:
+	case <-time.After(time.Second):
+		t.Fatal("cursor was not closed after cancellation")
+	}
+
+	d.mu.Lock()
+	queryCtx := d.queryCtx
+	d.mu.Unlock()
+	if queryCtx == nil || queryCtx.Err() == nil {
+		t.Error("query context was not cancelled")
+	}
+
+	// database/sql may have one row in flight when the cancellation lands
+	if served := d.served.Load(); served > int64(w.after)+10 {
+		t.Errorf("cursor served %d rows after cancelling at line %d", served, w.after)
+	}
+}
+
+func TestWriteMonthlyStatsCSV(t *testing.T) {
+	tests := []struct {
+		metric string
+		want   string
+	}{
+		{metric: "entrances", want: "month,entrances\n2025-09,120\n"},
+		{metric: "entrances,exits", want: "month,entrances,exits\n2025-09,120,110\n"},
+		{metric: "exits", want: "month,exits\n2025-09,110\n"},
+		{metric: "both", want: "month,entrances,exits,total\n2025-09,120,110,230\n"},
+		{metric: "net", want: "month,entrances,exits,net\n2025-09,120,110,10\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metric, func(t *testing.T) {
+			app := &App{now: time.Now}
+			rr := httptest.NewRecorder()
+			app.writeMonthlyStatsCSV(rr, tt.metric, []MonthlyStats{newMonthlyStats(tt.metric, "2025-09", 120, 110)})
+			if got := rr.Body.String(); got != tt.want {
+				t.Errorf("CSV = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCSVQuotesGateNames(t *testing.T) {
+	ts := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		gate string
+		want string
+	}{
+		{name: "plain", gate: "Gate 1", want: "2026-03-02 09:00:00,Gate 1,0,0,5,1,3,1\n"},
+		{name: "comma", gate: "Fairchild, East", want: "2026-03-02 09:00:00,\"Fairchild, East\",0,0,5,1,3,1\n"},
+		{name: "quote", gate: `Linderman "Main"`, want: "2026-03-02 09:00:00,\"Linderman \"\"Main\"\"\",0,0,5,1,3,1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDB{query: func(query string, _ []driver.NamedValue) (fakeResult, error) {
+				if strings.Contains(query, "MAX(timestamp)") {
+					return fakeResult{columns: []string{"MAX(timestamp)"}, rows: [][]driver.Value{{nil}}}, nil
+				}
+				return fakeResult{
+					columns: strings.Split(gateCountColumns, ", "),
+					rows:    [][]driver.Value{{int64(1), ts, tt.gate, "", int64(0), int64(0), int64(5), int64(1), int64(3), int64(1), false, false, statusOK}},
+				}, nil
+			}}
+			app := &App{db: d.open(t), table: "lib_gate_counts", now: time.Now, csvFilenameTemplate: "export.csv"}
+
+			w := httptest.NewRecorder()
+			app.writeCSV(context.Background(), w, QueryFilter{})
+
+			_, body, _ := strings.Cut(w.Body.String(), "\n")
+			if body != tt.want {
+				t.Errorf("row = %q, want %q", body, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// snakeCaseKey matches the field names our JSON tags use.
+var snakeCaseKey = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)+$`)
+
+// wantsCamelCase reports whether the client asked for camelCase field names,
+// with ?case=camel or a parameter on the JSON media type such as
+// "Accept: application/json; case=camel".
+func wantsCamelCase(r *http.Request) bool {
+	if r.URL.Query().Get("case") == "camel" {
+		return true
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "application/json" && params["case"] == "camel" {
+			return true
+		}
+	}
+	return false
+}
+
+// camelCaseWriter marks a response whose JSON field names writeJSON should
+// write in camelCase. Everything else, including streamed exports, goes
+// straight through to the wrapped writer.
+type camelCaseWriter struct {
+	http.ResponseWriter
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *camelCaseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// camelCaseMiddleware marks responses for clients that ask for camelCase
+// field names, so handlers and existing clients keep the one set of JSON tags.
+func camelCaseMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if wantsCamelCase(r) {
+			w = &camelCaseWriter{ResponseWriter: w}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// camelCaseRequested reports whether w, or a writer it wraps, was marked by
+// camelCaseMiddleware.
+func camelCaseRequested(w http.ResponseWriter) bool {
+	for {
+		if _, ok := w.(*camelCaseWriter); ok {
+			return true
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return false
+		}
+		w = u.Unwrap()
+	}
+}
+
+// withoutCamelCase returns the writer under camelCaseMiddleware's mark, for
+// responses that must keep their names as written.
+func withoutCamelCase(w http.ResponseWriter) http.ResponseWriter {
+	if cw, ok := w.(*camelCaseWriter); ok {
+		return cw.ResponseWriter
+	}
+	return w
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+// camelCaseFields returns v ready to encode with its struct field names, and
+// the snake_case keys of the map[string]interface{} objects handlers build,
+// in camelCase. Keys of typed maps are data, such as gate names or dates, and
+// are left alone, as are values that encode themselves.
+func camelCaseFields(v reflect.Value) any {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		return v.Interface()
+	}
+	if v.Kind() != reflect.Pointer && v.CanAddr() {
+		if pt := reflect.PointerTo(v.Type()); pt.Implements(jsonMarshalerType) || pt.Implements(textMarshalerType) {
+			return v.Addr().Interface()
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return camelCaseFields(v.Elem())
+	case reflect.Struct:
+		obj := &orderedObject{}
+		obj.addFields(v)
+		return obj
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return v.Interface()
+		}
+		isObject := v.Type().Elem().Kind() == reflect.Interface
+		out := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			k := iter.Key().String()
+			if isObject && snakeCaseKey.MatchString(k) {
+				k = snakeToCamel(k)
+			}
+			out[k] = camelCaseFields(iter.Value())
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) {
+			return v.Interface()
+		}
+		out := make([]any, v.Len())
+		for i := range out {
+			out[i] = camelCaseFields(v.Index(i))
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// orderedObject is a struct's fields under their converted names, encoded in
+// declaration order as encoding/json would have.
+type orderedObject struct {
+	keys   []string
+	values []any
+}
+
+// addFields follows encoding/json's tag rules, flattening embedded structs.
+func (o *orderedObject) addFields(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			if fv.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				o.addFields(fv)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && isEmptyValue(fv) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		} else if snakeCaseKey.MatchString(name) {
+			name = snakeToCamel(name)
+		}
+		o.keys = append(o.keys, name)
+		o.values = append(o.values, camelCaseFields(fv))
+	}
+}
+
+func (o *orderedObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(o.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// isEmptyValue matches encoding/json's omitempty test.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
+
+// snakeToCamel turns incoming_patrons_count into incomingPatronsCount.
+func snakeToCamel(s string) string {
+	parts := strings.Split(s, "_")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnakeToCamel(t *testing.T) {
+	tests := map[string]string{
+		"incoming_patrons_count": "incomingPatronsCount",
+		"has_data":               "hasData",
+		"count0":                 "count0",
+		"last_error_at":          "lastErrorAt",
+	}
+	for in, want := range tests {
+		if got := snakeToCamel(in); got != want {
+			t.Errorf("snakeToCamel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+type caseInner struct {
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type caseOuter struct {
+	GateName string               `json:"gate_name"`
+	Skipped  int                  `json:"skipped_count,omitempty"`
+	Hidden   string               `json:"-"`
+	PerHour  *float64             `json:"per_hour"`
+	ByGate   map[string]caseInner `json:"by_gate"`
+	caseInner
+}
+
+func TestWriteJSONCamelCase(t *testing.T) {
+	payload := map[string]interface{}{
+		"has_data": true,
+		"data": []caseOuter{{
+			GateName: "Main_Gate",
+			Hidden:   "secret",
+			ByGate:   map[string]caseInner{"north_gate": {}},
+		}},
+	}
+
+	tests := []struct {
+		name    string
+		target  string
+		accept  string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "snake case by default",
+			target:  "/",
+			want:    []string{`"has_data"`, `"gate_name"`, `"per_hour":null`, `"last_seen"`},
+			notWant: []string{`"hasData"`, `"gateName"`},
+		},
+		{
+			name:    "query parameter",
+			target:  "/?case=camel",
+			want:    []string{`"hasData"`, `"gateName":"Main_Gate"`, `"perHour":null`, `"byGate":{"north_gate":{"lastSeen"`, `"lastSeen"`},
+			notWant: []string{`"has_data"`, `"gate_name"`, `"skippedCount"`, `"Hidden"`, `"northGate"`},
+		},
+		{
+			name:   "accept parameter",
+			target: "/",
+			accept: "application/json; case=camel",
+			want:   []string{`"hasData"`, `"gateName"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := camelCaseMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				writeJSON(w, http.StatusOK, payload)
+			}))
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			body := rr.Body.String()
+			for _, s := range tt.want {
+				if !strings.Contains(body, s) {
+					t.Errorf("body %s missing %s", body, s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(body, s) {
+					t.Errorf("body %s contains %s", body, s)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryCamelCase(t *testing.T) {
+	d := &fakeDB{query: func(string, []driver.NamedValue) (fakeResult, error) {
+		return fakeResult{
+			columns: strings.Split(gateCountColumns, ", "),
+			rows: [][]driver.Value{
+				{int64(1), time.Now(), "Gate 1", "", int64(10), int64(0), int64(120), int64(4), int64(110), int64(3), false, false, statusOK},
+			},
+		}, nil
+	}}
+	app := &App{db: d.open(t), table: "lib_gate_counts", now: time.Now, queryTimeout: time.Minute}
+
+	h := camelCaseMiddleware(http.HandlerFunc(app.handleQuery))
+	req := httptest.NewRequest(http.MethodPost, "/query?case=camel", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `"incomingPatronsCount":120`) || strings.Contains(body, "incoming_patrons_count") {
+		t.Errorf("body %s isn't camelCase", body)
+	}
+}
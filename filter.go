@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueryFilter selects gate count readings. It is the request body of /query
+// and /download_csv and is read from the query string by GET endpoints that
+// accept the same filters.
+type QueryFilter struct {
+	GateName   string `json:"gate_name"`
+	Fuzzy      bool   `json:"fuzzy"`
+	Building   string `json:"building"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+	OrderBy    string `json:"order_by"`
+	AllowLarge bool   `json:"allow_large"`
+	Bounds     string `json:"bounds"`
+}
+
+func filterFromQuery(r *http.Request) QueryFilter {
+	q := r.URL.Query()
+	fuzzy, _ := strconv.ParseBool(q.Get("fuzzy"))
+	allowLarge, _ := strconv.ParseBool(q.Get("allow_large"))
+	return QueryFilter{
+		GateName:   q.Get("gate_name"),
+		Fuzzy:      fuzzy,
+		Building:   q.Get("building"),
+		StartDate:  q.Get("start_date"),
+		EndDate:    q.Get("end_date"),
+		OrderBy:    q.Get("order_by"),
+		AllowLarge: allowLarge,
+		Bounds:     q.Get("bounds"),
+	}
+}
+
+// Values for QueryFilter.Bounds. Inclusive end dates cover the whole of that
+// day; exclusive ones stop at its midnight.
+const (
+	boundsInclusive = "inclusive"
+	boundsExclusive = "exclusive"
+)
+
+// validateFilter checks a filter's dates and range and resolves its sort
+// order and bounds, applying the defaults. Build relies on a validated filter.
+func (app *App) validateFilter(f *QueryFilter) error {
+	orderBy, err := app.resolveOrderBy(f.OrderBy)
+	if err != nil {
+		return err
+	}
+	f.OrderBy = orderBy
+
+	if f.Building != "" && !app.hasBuilding(f.Building) {
+		return fmt.Errorf("unknown building %q", f.Building)
+	}
+
+	switch f.Bounds {
+	case "":
+		f.Bounds = boundsInclusive
+	case boundsInclusive, boundsExclusive:
+	default:
+		return fmt.Errorf("invalid bounds %q: must be inclusive or exclusive", f.Bounds)
+	}
+	return app.checkDateRange(f.StartDate, f.EndDate, f.AllowLarge)
+}
+
+// likeEscaper escapes LIKE wildcards so a fuzzy search for "North_2" only
+// matches that literal substring.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Build returns the WHERE clause and its bound arguments. Every value is a
+// placeholder, so the SQL itself never contains client input. An empty
+// GateName matches every gate; otherwise the name must match exactly unless
+// Fuzzy requests a substring search. Building limits the readings to that
+// building's.
+func (f QueryFilter) Build() (string, []any) {
+	where := " WHERE 1=1"
+	args := []any{}
+
+	if f.GateName != "" && f.Fuzzy {
+		where += " AND gate_name LIKE ?"
+		args = append(args, "%"+likeEscaper.Replace(f.GateName)+"%")
+	} else if f.GateName != "" {
+		where += " AND gate_name = ?"
+		args = append(args, f.GateName)
+	}
+
+	if f.Building != "" {
+		where += " AND building = ?"
+		args = append(args, f.Building)
+	}
+
+	// Dates are bound as local times so the driver converts them to whichever
+	// zone the readings are stored in.
+	if start, err := time.ParseInLocation(dateLayout, f.StartDate, time.Local); err == nil {
+		where += " AND timestamp >= ?"
+		args = append(args, start)
+	}
+
+	if end, err := time.ParseInLocation(dateLayout, f.EndDate, time.Local); err == nil {
+		if f.Bounds != boundsExclusive {
+			end = end.AddDate(0, 0, 1)
+		}
+		where += " AND timestamp < ?"
+		args = append(args, end)
+	}
+
+	return where, args
+}
+
+func (f QueryFilter) orderClause() string {
+	if f.OrderBy == "desc" {
+		return " ORDER BY timestamp DESC"
+	}
+	return " ORDER BY timestamp ASC"
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GateConfig holds per-gate overrides read from the GATE_CONFIG file, a JSON
+// object keyed by gate name:
+//
+//	{"FM West gate": {"counts": {"alarm": 0, "incoming": 2, "outgoing": 1}}}
+type GateConfig struct {
+	Counts *CountMapping `json:"counts,omitempty"`
+
+	// Direction is "entry", "exit" or "both" (the default). Occupancy only
+	// counts entrances through entry doors and exits through exit doors, so
+	// stray counts on a one-way door's other sensor are ignored.
+	Direction string `json:"direction,omitempty"`
+
+	// Interval overrides POLL_INTERVAL for this gate, e.g. "4h" for a device
+	// that throttles requests.
+	Interval string `json:"interval,omitempty"`
+	interval time.Duration
+
+	// Name records the gate's readings under a name other than the one
+	// derived from its URL. /admin/rename_gate sets it so polling keeps the
+	// new name.
+	Name string `json:"name,omitempty"`
+}
+
+const (
+	directionEntry = "entry"
+	directionExit  = "exit"
+	directionBoth  = "both"
+)
+
+// CountMapping says which XML counter (count0, count1 or count2) holds each
+// reading, for gates whose sensors are wired differently.
+type CountMapping struct {
+	Alarm    int `json:"alarm"`
+	Incoming int `json:"incoming"`
+	Outgoing int `json:"outgoing"`
+}
+
+var defaultCountMapping = CountMapping{Alarm: 0, Incoming: 1, Outgoing: 2}
+
+func loadGateConfig(path string) (map[string]GateConfig, error) {
+	if path == "" {
+		return map[string]GateConfig{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]GateConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	names := map[string]string{}
+	for key, gc := range config {
+		name := key
+		if gc.Name != "" {
+			name = gc.Name
+		}
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("gates %q and %q are both named %q", other, key, name)
+		}
+		names[name] = key
+	}
+
+	for name, gc := range config {
+		switch gc.Direction {
+		case "", directionEntry, directionExit, directionBoth:
+		default:
+			return nil, fmt.Errorf("gate %q: direction must be entry, exit or both", name)
+		}
+
+		if gc.Interval != "" {
+			d, err := time.ParseDuration(gc.Interval)
+			if err != nil || d < time.Minute {
+				return nil, fmt.Errorf("gate %q: interval must be a duration of at least 1m", name)
+			}
+			gc.interval = d
+			config[name] = gc
+		}
+
+		if gc.Counts == nil {
+			continue
+		}
+		m := *gc.Counts
+		seen := map[int]bool{}
+		for _, i := range []int{m.Alarm, m.Incoming, m.Outgoing} {
+			if i < 0 || i > 2 || seen[i] {
+				return nil, fmt.Errorf("gate %q: counts must map alarm, incoming and outgoing to distinct indexes 0-2", name)
+			}
+			seen[i] = true
+		}
+	}
+	return config, nil
+}
+
+// gateSettings returns the overrides for a gate, whether it is looked up by
+// the name derived from its URL or the name its readings are recorded under.
+func (app *App) gateSettings(gateName string) GateConfig {
+	app.gateConfigMu.RLock()
+	defer app.gateConfigMu.RUnlock()
+	if gc, ok := app.gateConfig[gateName]; ok {
+		return gc
+	}
+	for _, gc := range app.gateConfig {
+		if gc.Name == gateName {
+			return gc
+		}
+	}
+	return GateConfig{}
+}
+
+// pollName returns the gate name and building a source's readings are
+// recorded under, honouring a configured name.
+func (app *App) pollName(gate gateSource) (string, string) {
+	derived := getGateName(gate)
+	app.gateConfigMu.RLock()
+	name := app.gateConfig[derived].Name
+	app.gateConfigMu.RUnlock()
+	if name == "" {
+		return derived, gate.Building
+	}
+	return name, app.buildingOf(name)
+}
+
+// polledGateKey returns the GATE_CONFIG key of the polled gate currently
+// recorded as gateName, if any gate is.
+func (app *App) polledGateKey(gateName string) (string, bool) {
+	for _, gate := range app.gates {
+		if name, _ := app.pollName(gate); name == gateName {
+			return getGateName(gate), true
+		}
+	}
+	return "", false
+}
+
+// setGateName records that the polled gate configured under key is now
+// named name, writing the change to the GATE_CONFIG file so it survives a
+// restart. The previous config is returned so a failed rename can restore it.
+func (app *App) setGateName(key, name string) (map[string]GateConfig, error) {
+	app.gateConfigMu.Lock()
+	defer app.gateConfigMu.Unlock()
+
+	previous := app.gateConfig
+	next := make(map[string]GateConfig, len(previous)+1)
+	for k, v := range previous {
+		next[k] = v
+	}
+	gc := next[key]
+	gc.Name = name
+	if name == key {
+		gc.Name = ""
+	}
+	next[key] = gc
+
+	if err := writeGateConfig(app.gateConfigPath, next); err != nil {
+		return nil, err
+	}
+	app.gateConfig = next
+	return previous, nil
+}
+
+// restoreGateConfig puts back a config returned by setGateName.
+func (app *App) restoreGateConfig(config map[string]GateConfig) error {
+	app.gateConfigMu.Lock()
+	defer app.gateConfigMu.Unlock()
+	if err := writeGateConfig(app.gateConfigPath, config); err != nil {
+		return err
+	}
+	app.gateConfig = config
+	return nil
+}
+
+// writeGateConfig replaces the GATE_CONFIG file through a temporary file in
+// the same directory, so a crash mid-write can't leave it truncated.
+func writeGateConfig(path string, config map[string]GateConfig) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write GATE_CONFIG: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write GATE_CONFIG: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write GATE_CONFIG: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write GATE_CONFIG: %w", err)
+	}
+	return nil
+}
+
+// countMapping returns the counter layout for a gate, falling back to the
+// standard count0=alarm, count1=incoming, count2=outgoing.
+func (app *App) countMapping(gateName string) CountMapping {
+	if gc := app.gateSettings(gateName); gc.Counts != nil {
+		return *gc.Counts
+	}
+	return defaultCountMapping
+}
+
+// gateInterval returns how often a gate is polled.
+func (app *App) gateInterval(gateName string) time.Duration {
+	if gc := app.gateSettings(gateName); gc.interval > 0 {
+		return gc.interval
+	}
+	return app.pollInterval
+}
+
+// occupancyCounts returns the entrances and exits a gate contributes to
+// building occupancy given its direction.
+func (app *App) occupancyCounts(gateName string, entrances, exits int) (int, int) {
+	switch app.gateSettings(gateName).Direction {
+	case directionEntry:
+		return entrances, 0
+	case directionExit:
+		return 0, exits
+	default:
+		return entrances, exits
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadGateConfigNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{name: "no names", config: `{"Gate 1": {}, "Gate 2": {}}`},
+		{name: "renamed", config: `{"Gate 1": {"name": "Main entrance"}, "Gate 2": {}}`},
+		{name: "renamed onto another gate", config: `{"Gate 1": {"name": "Gate 2"}, "Gate 2": {}}`, wantErr: true},
+		{name: "two gates with one name", config: `{"Gate 1": {"name": "Main"}, "Gate 2": {"name": "Main"}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "gates.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadGateConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadGateConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetGateName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gates.json")
+	if err := os.WriteFile(path, []byte(`{"Gate 1": {"interval": "4h"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := loadGateConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gate := gateSource{URL: "http://gate1.example.edu/counts", Index: 0}
+	app := &App{gateConfig: config, gateConfigPath: path, gates: []gateSource{gate}}
+
+	key, polled := app.polledGateKey("Gate 1")
+	if !polled || key != "Gate 1" {
+		t.Fatalf("polledGateKey(Gate 1) = %q, %v", key, polled)
+	}
+	if _, err := app.setGateName(key, "Main entrance"); err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := app.pollName(gate); name != "Main entrance" {
+		t.Errorf("pollName() = %q after the rename", name)
+	}
+	if got := app.gateInterval("Main entrance"); got.Hours() != 4 {
+		t.Errorf("gateInterval(Main entrance) = %s, want the renamed gate's 4h", got)
+	}
+
+	reloaded, err := loadGateConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded["Gate 1"].Name != "Main entrance" || reloaded["Gate 1"].Interval != "4h" {
+		t.Errorf("GATE_CONFIG after the rename = %+v", reloaded)
+	}
+
+	// Renaming back to the derived name clears the override
+	if _, err := app.setGateName(key, "Gate 1"); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := app.pollName(gate); name != "Gate 1" {
+		t.Errorf("pollName() = %q after renaming back", name)
+	}
+}
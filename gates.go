@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type GateInfo struct {
+	Name        string `json:"name"`
+	Location    string `json:"location"`
+	Description string `json:"description"`
+	GateStatus
+}
+
+// handleGates lists every known gate along with its display metadata.
+func (app *App) handleGates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	gates, err := app.listGates(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    gates,
+	})
+}
+
+func (app *App) listGates(ctx context.Context) ([]GateInfo, error) {
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT g.gate_name, COALESCE(m.location, ''), COALESCE(m.description, '')
+		FROM (
+			SELECT DISTINCT gate_name FROM `+app.table+` WHERE gate_name IS NOT NULL
+			UNION
+			SELECT gate_name FROM `+companionTable(app.table, "meta")+`
+		) g
+		LEFT JOIN `+companionTable(app.table, "meta")+` m ON m.gate_name = g.gate_name
+		ORDER BY g.gate_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	gates := []GateInfo{}
+	for rows.Next() {
+		var g GateInfo
+		if err := rows.Scan(&g.Name, &g.Location, &g.Description); err != nil {
+			return nil, err
+		}
+		g.GateStatus = app.gateStatus.get(g.Name)
+		gates = append(gates, g)
+	}
+	return gates, rows.Err()
+}
+
+type GateOverview struct {
+	GateName     string    `json:"gate_name"`
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
+	Rows         int       `json:"rows"`
+	DaysWithData int       `json:"days_with_data"`
+}
+
+// handleGatesOverview summarises each gate's history so gates with large gaps,
+// or ones that stopped reporting long ago, are easy to spot.
+func (app *App) handleGatesOverview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT gate_name, MIN(timestamp), MAX(timestamp), COUNT(*), COUNT(DISTINCT DATE(`+app.localTimestamp()+`))
+		FROM `+app.table+`
+		WHERE gate_name IS NOT NULL
+		GROUP BY gate_name
+		ORDER BY gate_name
+	`)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	overview := []GateOverview{}
+	for rows.Next() {
+		var g GateOverview
+		if err := rows.Scan(&g.GateName, &g.FirstSeen, &g.LastSeen, &g.Rows, &g.DaysWithData); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		g.FirstSeen, g.LastSeen = g.FirstSeen.In(time.Local), g.LastSeen.In(time.Local)
+		overview = append(overview, g)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    overview,
+	})
+}
+
+// handleGateMeta lets an admin set the location and description shown for a
+// gate.
+func (app *App) handleGateMeta(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.PathValue("name"))
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "gate name is required")
+		return
+	}
+
+	var req struct {
+		Location    string `json:"location"`
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	if _, err := app.db.ExecContext(ctx, `
+		INSERT INTO `+companionTable(app.table, "meta")+` (gate_name, location, description)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE location = VALUES(location), description = VALUES(description)
+	`, name, req.Location, req.Description); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	slog.Info("Gate metadata updated", "gate", name, "location", req.Location, "client_ip", clientIP(r))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data": GateInfo{
+			Name:        name,
+			Location:    req.Location,
+			Description: req.Description,
+		},
+	})
+}
+
+// handleRenameGate moves a gate's history from one name to another (POST
+// {"from": ..., "to": ...}) in a single transaction: its readings, metadata,
+// running totals and purge baseline. Readings take the building the new name
+// belongs to. A name that already has readings is refused, since merging two
+// counters' readings would diff one against the other. Totals and baselines
+// left under the new name by a purge are added together, and existing
+// metadata for the new name wins. When a polled gate is renamed the new name
+// is written to GATE_CONFIG so later polls record under it.
+func (app *App) handleRenameGate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	req.From, req.To = strings.TrimSpace(req.From), strings.TrimSpace(req.To)
+	if req.From == "" || req.To == "" {
+		writeError(w, http.StatusBadRequest, "from and to are required")
+		return
+	}
+	if req.From == req.To {
+		writeError(w, http.StatusBadRequest, "from and to must differ")
+		return
+	}
+
+	if _, polled := app.polledGateKey(req.To); polled {
+		writeError(w, http.StatusConflict, fmt.Sprintf("gate %q is polled under that name already", req.To))
+		return
+	}
+	if _, polled := app.polledGateKey(req.From); polled && app.gateConfigPath == "" {
+		writeError(w, http.StatusBadRequest, "set GATE_CONFIG to rename a polled gate, so polling records under the new name")
+		return
+	}
+
+	// Hold off the poller so no reading lands under the old name mid-rename
+	app.pollMu.Lock()
+	defer app.pollMu.Unlock()
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	n, err := app.renameGate(ctx, req.From, req.To)
+	if errors.Is(err, errGateExists) {
+		writeError(w, http.StatusConflict, fmt.Sprintf("gate %q already has readings; rename it first or delete them", req.To))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	slog.Info("Gate renamed", "from", req.From, "to", req.To, "rows_changed", n, "client_ip", clientIP(r))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"rows_changed": n,
+	})
+}
+
+// errGateExists is returned by renameGate when the new name has readings.
+var errGateExists = errors.New("gate already has readings")
+
+func (app *App) renameGate(ctx context.Context, from, to string) (int64, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var existing int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+app.table+" WHERE gate_name = ?", to).Scan(&existing); err != nil {
+		return 0, err
+	}
+	if existing > 0 {
+		return 0, errGateExists
+	}
+
+	// The building moves with the name so the poller's last-reading lookup,
+	// which matches both, finds the renamed history
+	res, err := tx.ExecContext(ctx, "UPDATE "+app.table+" SET gate_name = ?, building = ? WHERE gate_name = ?", to, app.buildingOf(to), from)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	meta, totals, baseline := companionTable(app.table, "meta"), companionTable(app.table, "totals"), companionTable(app.table, "baseline")
+	stmts := []string{
+		`UPDATE IGNORE ` + meta + ` SET gate_name = ? WHERE gate_name = ?`,
+		`INSERT INTO ` + totals + ` (gate_name, entrances, exits, alarms, updated_at)
+		SELECT ?, entrances, exits, alarms, updated_at FROM ` + totals + ` WHERE gate_name = ?
+		ON DUPLICATE KEY UPDATE
+			entrances = entrances + VALUES(entrances),
+			exits = exits + VALUES(exits),
+			alarms = alarms + VALUES(alarms),
+			updated_at = GREATEST(COALESCE(updated_at, VALUES(updated_at)), COALESCE(VALUES(updated_at), updated_at))`,
+		`INSERT INTO ` + baseline + ` (gate_name, entrances, exits, alarms, through)
+		SELECT ?, entrances, exits, alarms, through FROM ` + baseline + ` WHERE gate_name = ?
+		ON DUPLICATE KEY UPDATE
+			entrances = entrances + VALUES(entrances),
+			exits = exits + VALUES(exits),
+			alarms = alarms + VALUES(alarms),
+			through = GREATEST(COALESCE(through, VALUES(through)), COALESCE(VALUES(through), through))`,
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt, to, from); err != nil {
+			return 0, err
+		}
+	}
+	for _, table := range []string{meta, totals, baseline} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE gate_name = ?", from); err != nil {
+			return 0, err
+		}
+	}
+
+	// The running total is recomputed so it includes any baseline merged
+	// above
+	if err := app.resetRunningTotal(ctx, tx, to); err != nil {
+		return 0, err
+	}
+
+	// The config is written before committing so a failed write leaves the
+	// readings where the poller will keep adding to them
+	var previous map[string]GateConfig
+	key, polled := app.polledGateKey(from)
+	if polled {
+		if previous, err = app.setGateName(key, to); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		if polled {
+			if restoreErr := app.restoreGateConfig(previous); restoreErr != nil {
+				slog.Error("Failed to restore GATE_CONFIG after a failed rename", "error", restoreErr)
+			}
+		}
+		return 0, err
+	}
+	app.markEdited()
+	return n, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cmdScheme marks a gate "URL" that is a local command to run instead of an
+// HTTP endpoint, e.g. "cmd:///usr/local/bin/counter-cli --gate 3", for
+// counters only reachable through a vendor CLI.
+const cmdScheme = "cmd://"
+
+// maxGateBodyBytes caps how much of a gate's response is read, over HTTP or
+// from a command.
+const maxGateBodyBytes = 1 << 20
+
+// decodeRetryDelay is how long to wait before fetching a gate again after a
+// response that isn't counter data.
+const decodeRetryDelay = 2 * time.Second
+
+// fetchGateBody returns a gate's raw counter response, either over HTTP or
+// from the stdout of a cmd:// command.
+func (app *App) fetchGateBody(ctx context.Context, gateURL string) ([]byte, error) {
+	if command, ok := strings.CutPrefix(gateURL, cmdScheme); ok {
+		args := strings.Fields(command)
+		if len(args) == 0 {
+			return nil, fmt.Errorf("empty gate command")
+		}
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stderr = &stderr
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("gate command failed: %w", err)
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("gate command failed: %w", err)
+		}
+		// One byte past the cap is read to tell a full response from an
+		// oversized one, which is stopped rather than left blocked on a pipe
+		out, readErr := io.ReadAll(io.LimitReader(stdout, maxGateBodyBytes+1))
+		if len(out) > maxGateBodyBytes {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return nil, fmt.Errorf("gate command output exceeds %d bytes", maxGateBodyBytes)
+		}
+		if err := cmd.Wait(); err != nil {
+			return nil, fmt.Errorf("gate command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read gate command output: %w", readErr)
+		}
+		return out, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", gateURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", app.userAgent)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch gate data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad response from %s: %d", gateURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxGateBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gate response: %w", err)
+	}
+	return body, nil
+}
+
+// gateBodyCounts mirrors GateXMLResponse with pointers, so a document that
+// decodes but lacks a count can be told apart from a counter reading zero.
+type gateBodyCounts struct {
+	Count0 *int `xml:"count0" json:"count0"`
+	Count1 *int `xml:"count1" json:"count1"`
+	Count2 *int `xml:"count2" json:"count2"`
+}
+
+// parseGateBody decodes counter XML, or the same fields as a JSON object,
+// which some command sources print instead. All three counts must be present;
+// an error object or unrelated document would otherwise read as a reset.
+func parseGateBody(body []byte) (GateXMLResponse, error) {
+	var counts gateBodyCounts
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, &counts)
+	} else {
+		err = xml.Unmarshal(body, &counts)
+	}
+	if err != nil {
+		return GateXMLResponse{}, err
+	}
+
+	var missing []string
+	for name, v := range map[string]*int{"count0": counts.Count0, "count1": counts.Count1, "count2": counts.Count2} {
+		if v == nil {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return GateXMLResponse{}, fmt.Errorf("response has no %s", strings.Join(missing, ", "))
+	}
+	return GateXMLResponse{Count0: *counts.Count0, Count1: *counts.Count1, Count2: *counts.Count2}, nil
+}
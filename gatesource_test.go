@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestParseGateBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    GateXMLResponse
+		wantErr bool
+	}{
+		{
+			name: "xml",
+			body: `<response><count0>3</count0><count1>120</count1><count2>118</count2></response>`,
+			want: GateXMLResponse{Count0: 3, Count1: 120, Count2: 118},
+		},
+		{
+			name: "xml zero counts",
+			body: `<response><count0>0</count0><count1>0</count1><count2>0</count2></response>`,
+			want: GateXMLResponse{},
+		},
+		{
+			name: "json",
+			body: ` {"count0": 1, "count1": 2, "count2": 3}`,
+			want: GateXMLResponse{Count0: 1, Count1: 2, Count2: 3},
+		},
+		{name: "empty json object", body: `{}`, wantErr: true},
+		{name: "json error object", body: `{"error": "unauthorized"}`, wantErr: true},
+		{name: "json missing a count", body: `{"count0": 1, "count1": 2}`, wantErr: true},
+		{name: "xml without counts", body: `<html><body>Please log in</body></html>`, wantErr: true},
+		{name: "xml missing a count", body: `<response><count0>1</count0><count2>2</count2></response>`, wantErr: true},
+		{name: "not a document", body: `Service Unavailable`, wantErr: true},
+		{name: "empty", body: ``, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseGateBody([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseGateBody(%q) = %+v, want an error", tt.body, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseGateBody(%q) error: %v", tt.body, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseGateBody(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// GateStatus is the in-memory polling state of a gate, kept so operators can
+// see why a gate isn't reporting without searching the logs.
+type GateStatus struct {
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	Down                bool       `json:"down"`
+	UnchangedCycles     int        `json:"unchanged_cycles"`
+	Stuck               bool       `json:"stuck"`
+	LastPollSeconds     float64    `json:"last_poll_seconds"`
+	AvgPollSeconds      float64    `json:"avg_poll_seconds"`
+}
+
+// pollDurationWindow is how many recent polls AvgPollSeconds averages over.
+const pollDurationWindow = 10
+
+type gateStatusTracker struct {
+	mu        sync.Mutex
+	byGate    map[string]GateStatus
+	durations map[string][]time.Duration
+
+	// downAfter is how many consecutive failed cycles mark a gate down, so
+	// a single transient blip doesn't.
+	downAfter int
+
+	// stuckAfter is how many open-hours polls in a row with identical raw
+	// counts mark a gate stuck; 0 disables the check.
+	stuckAfter int
+}
+
+// record notes the outcome and duration of polling a gate. A success resets
+// the failure count but leaves the last error in place so it's still visible
+// after the gate recovers.
+func (t *gateStatusTracker) record(gateName string, err error, at time.Time, took time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byGate == nil {
+		t.byGate = map[string]GateStatus{}
+		t.durations = map[string][]time.Duration{}
+	}
+
+	status := t.byGate[gateName]
+
+	// A creeping poll time tends to precede a gate failing outright
+	recent := append(t.durations[gateName], took)
+	if len(recent) > pollDurationWindow {
+		recent = recent[len(recent)-pollDurationWindow:]
+	}
+	t.durations[gateName] = recent
+	var total time.Duration
+	for _, d := range recent {
+		total += d
+	}
+	status.LastPollSeconds = took.Seconds()
+	status.AvgPollSeconds = total.Seconds() / float64(len(recent))
+
+	if err == nil {
+		if status.Down {
+			slog.Info("Gate recovered", "gate", gateName, "failures", status.ConsecutiveFailures)
+		}
+		status.ConsecutiveFailures = 0
+		status.Down = false
+		status.LastSuccessAt = &at
+		t.byGate[gateName] = status
+		return
+	}
+
+	status.LastError = err.Error()
+	status.LastErrorAt = &at
+	status.ConsecutiveFailures++
+	if !status.Down && status.ConsecutiveFailures >= max(t.downAfter, 1) {
+		status.Down = true
+		slog.Error("Gate is down", "gate", gateName, "failures", status.ConsecutiveFailures, "error", err)
+	}
+	t.byGate[gateName] = status
+}
+
+// down lists the gates currently past the failure threshold.
+func (t *gateStatusTracker) down() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := []string{}
+	for name, status := range t.byGate {
+		if status.Down {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// recordCounts notes whether a poll taken during open hours saw the same raw
+// counts as the last reading. It reports true when that makes the gate stuck,
+// which is a frozen sensor rather than a quiet hour once enough polls agree.
+func (t *gateStatusTracker) recordCounts(gateName string, unchanged bool) (becameStuck bool) {
+	if t.stuckAfter <= 0 {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byGate == nil {
+		t.byGate = map[string]GateStatus{}
+		t.durations = map[string][]time.Duration{}
+	}
+
+	status := t.byGate[gateName]
+	if !unchanged {
+		if status.Stuck {
+			slog.Info("Gate counts changing again", "gate", gateName, "unchanged_cycles", status.UnchangedCycles)
+		}
+		status.UnchangedCycles = 0
+		status.Stuck = false
+		t.byGate[gateName] = status
+		return false
+	}
+
+	status.UnchangedCycles++
+	if !status.Stuck && status.UnchangedCycles >= t.stuckAfter {
+		status.Stuck = true
+		becameStuck = true
+	}
+	t.byGate[gateName] = status
+	return becameStuck
+}
+
+// stuck lists the gates whose counts have stopped changing during open hours.
+func (t *gateStatusTracker) stuck() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	names := []string{}
+	for name, status := range t.byGate {
+		if status.Stuck {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (t *gateStatusTracker) get(gateName string) GateStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.byGate[gateName]
+}
+
+// succeededSince reports whether any gate was polled successfully at or after
+// since, which stands in for recent rows when unchanged readings are skipped.
+func (t *gateStatusTracker) succeededSince(since time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, status := range t.byGate {
+		if status.LastSuccessAt != nil && !status.LastSuccessAt.Before(since) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *gateStatusTracker) snapshot() map[string]GateStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make(map[string]GateStatus, len(t.byGate))
+	for name, status := range t.byGate {
+		out[name] = status
+	}
+	return out
+}
+
+type GateHealth struct {
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	GateStatus
+}
+
+// gateHealth combines each gate's polling state with when it last recorded
+// a reading, for the verbose health check.
+func (app *App) gateHealth(ctx context.Context) (map[string]GateHealth, error) {
+	health := map[string]GateHealth{}
+	for name, status := range app.gateStatus.snapshot() {
+		health[name] = GateHealth{GateStatus: status}
+	}
+
+	rows, err := app.db.QueryContext(ctx, "SELECT gate_name, MAX(timestamp) FROM "+app.table+" WHERE gate_name IS NOT NULL AND failed = 0 GROUP BY gate_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var lastSeen time.Time
+		if err := rows.Scan(&name, &lastSeen); err != nil {
+			return nil, err
+		}
+		h := health[name]
+		h.LastSeen = &lastSeen
+		health[name] = h
+	}
+	return health, rows.Err()
+}
+
+func dbStats(s sql.DBStats) map[string]interface{} {
+	return map[string]interface{}{
+		"max_open_connections": s.MaxOpenConnections,
+		"open_connections":     s.OpenConnections,
+		"in_use":               s.InUse,
+		"idle":                 s.Idle,
+		"wait_count":           s.WaitCount,
+		"wait_duration":        s.WaitDuration.String(),
+		"max_idle_closed":      s.MaxIdleClosed,
+		"max_lifetime_closed":  s.MaxLifetimeClosed,
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openSpan is the hours a library is open on a day, start inclusive and end
+// exclusive. A closed day is 0-0; Start is -1 when no hours are configured.
+type openSpan struct {
+	Start, End int
+}
+
+// hours is how long the span is open, or -1 when it isn't configured.
+func (s openSpan) hours() int {
+	if s.Start < 0 {
+		return -1
+	}
+	return s.End - s.Start
+}
+
+// openHours is the opening hours of each weekday.
+type openHours [7]openSpan
+
+// isOpen reports whether t falls within configured opening hours. Days with
+// no configured hours are never considered open.
+func (h openHours) isOpen(t time.Time) bool {
+	span := h[t.Weekday()]
+	return span.Start >= 0 && t.Hour() >= span.Start && t.Hour() < span.End
+}
+
+// activeHourWeeks is how far back inferred opening hours look, and
+// activeHourMinDays how many of those weeks an hour needs entrances on to
+// count as open, so one late event doesn't keep the hour open.
+const (
+	activeHourWeeks   = 4
+	activeHourMinDays = 2
+)
+
+// activeHourCache holds the hours of each weekday inferred to be open from
+// recent entrances. Each weekday is refreshed once a day in the background,
+// so polls never wait on the history query.
+type activeHourCache struct {
+	mu         sync.Mutex
+	loadedAt   [7]time.Time
+	refreshing [7]bool
+	open       [7][24]bool
+}
+
+// isOpenAt reports whether the library is open at t, using OPEN_HOURS for
+// configured weekdays and hours that recently saw entrances otherwise. Until
+// a weekday's hours have been inferred its hours count as closed.
+func (app *App) isOpenAt(t time.Time) bool {
+	t = t.In(time.Local)
+	wd := t.Weekday()
+	if app.openHours[wd].Start >= 0 {
+		return app.openHours.isOpen(t)
+	}
+
+	c := &app.activeHours
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.refreshing[wd] && (c.loadedAt[wd].IsZero() || t.Sub(c.loadedAt[wd]) >= 24*time.Hour) {
+		c.refreshing[wd] = true
+		go app.refreshActiveHours(t)
+	}
+	return c.open[wd][t.Hour()]
+}
+
+// refreshActiveHours reloads the inferred hours of now's weekday. A failed
+// refresh keeps the previous hours until the next day rather than querying
+// on every poll.
+func (app *App) refreshActiveHours(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.queryTimeout)
+	defer cancel()
+	open, err := app.loadActiveHours(ctx, now)
+	if err != nil {
+		slog.Warn("Failed to infer open hours", "weekday", now.Weekday(), "error", err)
+	}
+
+	wd := now.Weekday()
+	c := &app.activeHours
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.open[wd] = open
+	}
+	c.loadedAt[wd] = now
+	c.refreshing[wd] = false
+}
+
+// loadActiveHours finds the hours of now's weekday that saw entrances on at
+// least activeHourMinDays days over the activeHourWeeks weeks before now.
+func (app *App) loadActiveHours(ctx context.Context, now time.Time) ([24]bool, error) {
+	var open [24]bool
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT DISTINCT timestamp
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND incoming_diff > 0 AND failed = 0 AND suspect = 0
+		AND DAYOFWEEK(`+app.localTimestamp()+`) = ?
+	`, now.AddDate(0, 0, -7*activeHourWeeks), int(now.Weekday())+1)
+	if err != nil {
+		return open, err
+	}
+	defer rows.Close()
+
+	var days [24]map[string]bool
+	for rows.Next() {
+		var ts time.Time
+		if err := rows.Scan(&ts); err != nil {
+			return open, err
+		}
+		ts = ts.In(time.Local)
+		if ts.Weekday() != now.Weekday() {
+			continue
+		}
+		if days[ts.Hour()] == nil {
+			days[ts.Hour()] = map[string]bool{}
+		}
+		days[ts.Hour()][ts.Format(dateLayout)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return open, err
+	}
+
+	for h := range days {
+		open[h] = len(days[h]) >= activeHourMinDays
+	}
+	return open, nil
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseOpenHours reads a comma separated list of weekday (or weekday range)
+// hours such as "mon-thu=7-24,fri=7-20,sat=10-18,sun=closed". Hours are
+// whole, with the end exclusive.
+func parseOpenHours(s string) (openHours, error) {
+	var hours openHours
+	for d := range hours {
+		hours[d] = openSpan{Start: -1, End: -1}
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		days, span, ok := strings.Cut(part, "=")
+		if !ok {
+			return hours, fmt.Errorf("invalid open hours %q: expected day=start-end", part)
+		}
+
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(days)), "-")
+		if !isRange {
+			last = first
+		}
+		from, ok1 := weekdayNames[first]
+		to, ok2 := weekdayNames[last]
+		if !ok1 || !ok2 {
+			return hours, fmt.Errorf("invalid open hours %q: unknown weekday", part)
+		}
+
+		open := openSpan{}
+		if span = strings.TrimSpace(span); span != "closed" {
+			a, b, _ := strings.Cut(span, "-")
+			start, err1 := strconv.Atoi(a)
+			end, err2 := strconv.Atoi(b)
+			if err1 != nil || err2 != nil || start < 0 || end > 24 || end <= start {
+				return hours, fmt.Errorf("invalid open hours %q: expected hours like 7-24", part)
+			}
+			open = openSpan{Start: start, End: end}
+		}
+
+		for d := from; ; d = (d + 1) % 7 {
+			hours[d] = open
+			if d == to {
+				break
+			}
+		}
+	}
+	return hours, nil
+}
+
+type NormalizedDay struct {
+	Date             string   `json:"date"`
+	Entrances        int      `json:"entrances"`
+	OpenHours        int      `json:"open_hours"`
+	HoursSource      string   `json:"hours_source"`
+	PerOpenHour      *float64 `json:"per_open_hour"`
+	InsufficientData bool     `json:"insufficient_data"`
+}
+
+// handleNormalizedDaily divides each day's entrances by the hours the library
+// was open, so a short Saturday compares fairly with a long weekday. Open
+// hours come from OPEN_HOURS where configured for that weekday, and are
+// otherwise inferred as the number of hourly polls that saw any entrances.
+func (app *App) handleNormalizedDaily(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 30, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0)
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ?`+filter+`
+		GROUP BY timestamp
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	entrances := map[string]int{}
+	activeHours := map[string]map[int]bool{}
+	for rows.Next() {
+		var ts time.Time
+		var in int
+		if err := rows.Scan(&ts, &in); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		ts = ts.In(time.Local)
+		day := ts.Format(dateLayout)
+		entrances[day] += in
+		if in > 0 {
+			if activeHours[day] == nil {
+				activeHours[day] = map[int]bool{}
+			}
+			activeHours[day][ts.Hour()] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := app.now()
+	results := []NormalizedDay{}
+	for d := start; d.Before(end) && !d.After(now); d = d.AddDate(0, 0, 1) {
+		day := d.Format(dateLayout)
+		n := NormalizedDay{Date: day, Entrances: entrances[day]}
+		if configured := app.openHours[d.Weekday()].hours(); configured >= 0 {
+			n.OpenHours, n.HoursSource = configured, "configured"
+		} else {
+			n.OpenHours, n.HoursSource = len(activeHours[day]), "inferred"
+		}
+		// A couple of busy polls make a poor estimate of a day's hours, so
+		// inferred days need as many as the trend needs weeks
+		n.InsufficientData = n.HoursSource == "inferred" && n.OpenHours < max(app.minAverageSamples, 1)
+		if !n.InsufficientData {
+			perOpenHour := 0.0
+			if n.OpenHours > 0 {
+				perOpenHour = app.round(float64(n.Entrances) / float64(n.OpenHours))
+			}
+			n.PerOpenHour = &perOpenHour
+		}
+		results = append(results, n)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    results,
+	})
+}
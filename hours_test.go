@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestIsOpenAtInfersUnconfiguredWeekdays(t *testing.T) {
+	// Monday has configured hours; Tuesday is inferred from entrances at
+	// 10:00 on two earlier Tuesdays and 22:00 on only one
+	hours, err := parseOpenHours("mon=8-17")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuesday := func(week, hour int) time.Time {
+		return time.Date(2026, 3, 3+7*week, hour, 0, 0, 0, time.Local)
+	}
+	d := &fakeDB{query: func(string, []driver.NamedValue) (fakeResult, error) {
+		return fakeResult{columns: []string{"timestamp"}, rows: [][]driver.Value{
+			{tuesday(-1, 10)}, {tuesday(-2, 10)}, {tuesday(-1, 22)},
+		}}, nil
+	}}
+	app := &App{db: d.open(t), table: "lib_gate_counts", openHours: hours, queryTimeout: time.Minute}
+
+	monday := time.Date(2026, 3, 2, 0, 0, 0, 0, time.Local)
+	for _, tt := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{monday.Add(9 * time.Hour), true},
+		{monday.Add(18 * time.Hour), false},
+	} {
+		if got := app.isOpenAt(tt.at); got != tt.want {
+			t.Errorf("isOpenAt(%s) = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+
+	// The first lookup starts the background refresh and counts as closed
+	if app.isOpenAt(tuesday(0, 10)) {
+		t.Error("isOpenAt before the inferred hours loaded = true")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		app.activeHours.mu.Lock()
+		loaded := !app.activeHours.loadedAt[time.Tuesday].IsZero()
+		app.activeHours.mu.Unlock()
+		if loaded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("inferred hours never loaded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, tt := range []struct {
+		hour int
+		want bool
+	}{
+		{10, true},
+		{22, false},
+		{3, false},
+	} {
+		if got := app.isOpenAt(tuesday(0, tt.hour)); got != tt.want {
+			t.Errorf("isOpenAt(Tuesday %02d:00) = %v, want %v", tt.hour, got, tt.want)
+		}
+	}
+}
@@ -1,18 +1,51 @@
 CREATE DATABASE `ole`;
 USE `ole`;
 CREATE TABLE `lib_gate_counts` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `timestamp` datetime DEFAULT NULL,
   `gate_name` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT NULL,
+  `building` varchar(64) NOT NULL DEFAULT '',
   `alarm_count` int(11) DEFAULT NULL,
   `alarm_diff` int(11) DEFAULT NULL,
   `incoming_patrons_count` int(11) DEFAULT NULL,
   `incoming_diff` int(11) DEFAULT NULL,
   `outgoing_patrons_count` int(11) DEFAULT NULL,
   `outgoing_diff` int(11) DEFAULT NULL,
+  `suspect` tinyint(1) NOT NULL DEFAULT 0,
+  `failed` tinyint(1) NOT NULL DEFAULT 0,
+  `status` varchar(16) NOT NULL DEFAULT 'ok',
+  `polled_through` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
   KEY `lib_gate_time_idx` (`timestamp`),
   KEY `lib_gate_name_idx` (`gate_name`),
-  KEY `lib_gate_time_name_idx` (`timestamp`,`gate_name`)
+  KEY `lib_gate_time_name_idx` (`timestamp`,`gate_name`),
+  KEY `lib_gate_building_time_idx` (`building`,`timestamp`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `lib_gate_meta` (
+  `gate_name` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL,
+  `location` varchar(255) NOT NULL DEFAULT '',
+  `description` text NOT NULL,
+  PRIMARY KEY (`gate_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `lib_gate_totals` (
+  `gate_name` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL,
+  `entrances` bigint NOT NULL DEFAULT 0,
+  `exits` bigint NOT NULL DEFAULT 0,
+  `alarms` bigint NOT NULL DEFAULT 0,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`gate_name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE `lib_gate_baseline` (
+  `gate_name` varchar(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL,
+  `entrances` bigint NOT NULL DEFAULT 0,
+  `exits` bigint NOT NULL DEFAULT 0,
+  `alarms` bigint NOT NULL DEFAULT 0,
+  `through` datetime DEFAULT NULL,
+  PRIMARY KEY (`gate_name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 CREATE USER `ole`@`%` IDENTIFIED BY 'CHANGEME';
-GRANT ALL PRIVILEGES ON ole.* TO `ole`@`%`
+GRANT ALL PRIVILEGES ON ole.* TO `ole`@`%`;
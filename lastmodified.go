@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// latestReading returns when the readings matched by where last changed: the
+// newest timestamp among them, or the last edit, delete, backfill or purge if
+// that was later. An edit to old rows doesn't move MAX(timestamp), so without
+// the edit time a cached stat would be answered 304 after a correction. The
+// edit time is kept in memory and starts at process start, so a restart only
+// ever costs a full response.
+func (app *App) latestReading(ctx context.Context, where string, args []any) (time.Time, error) {
+	var latest sql.NullTime
+	err := app.db.QueryRowContext(ctx, "SELECT MAX(timestamp) FROM "+app.table+where, args...).Scan(&latest)
+	if edited := app.lastEdited.Load(); edited != 0 && time.Unix(0, edited).After(latest.Time) {
+		return time.Unix(0, edited), err
+	}
+	return latest.Time, err
+}
+
+// markEdited records that readings were changed other than by polling.
+func (app *App) markEdited() {
+	app.lastEdited.Store(app.now().UnixNano())
+}
+
+func setLastModified(w http.ResponseWriter, t time.Time) {
+	if !t.IsZero() {
+		w.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified sets Last-Modified from the newest matching reading and, if
+// the request's If-Modified-Since is at least that recent, answers 304 and
+// returns true. It only suits responses whose content depends solely on the
+// matched rows, not on the current time.
+func (app *App) notModified(ctx context.Context, w http.ResponseWriter, r *http.Request, where string, args []any) bool {
+	latest, err := app.latestReading(ctx, where, args)
+	if err != nil || latest.IsZero() {
+		return false
+	}
+	setLastModified(w, latest)
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || latest.Truncate(time.Second).After(since) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -4,58 +4,155 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 type GateCount struct {
+	ID                   int64     `json:"id"`
 	Timestamp            time.Time `json:"timestamp"`
 	GateName             string    `json:"gate_name"`
+	Building             string    `json:"building"`
 	AlarmCount           int       `json:"alarm_count"`
 	AlarmDiff            int       `json:"alarm_diff"`
 	IncomingPatronsCount int       `json:"incoming_patrons_count"`
 	IncomingDiff         int       `json:"incoming_diff"`
 	OutgoingPatronsCount int       `json:"outgoing_patrons_count"`
 	OutgoingDiff         int       `json:"outgoing_diff"`
+	Suspect              bool      `json:"suspect"`
+	Failed               bool      `json:"failed"`
+	Status               string    `json:"status"`
 }
 
 type MonthlyStats struct {
 	Month     string `json:"month"`
-	Entrances int    `json:"entrances"`
+	Entrances *int   `json:"entrances,omitempty"`
+	Exits     *int   `json:"exits,omitempty"`
+	Total     *int   `json:"total,omitempty"`
+	Net       *int   `json:"net,omitempty"`
 }
 
 type RecentStats struct {
-	TotalEntrances int `json:"total_entrances"`
-	TotalExits     int `json:"total_exits"`
+	TotalEntrances int               `json:"total_entrances"`
+	TotalExits     int               `json:"total_exits"`
+	TotalAlarms    int               `json:"total_alarms"`
+	Gates          []GateRecentStats `json:"gates,omitempty"`
+}
+
+type GateRecentStats struct {
+	GateName       string `json:"gate_name"`
+	TotalEntrances int    `json:"total_entrances"`
+	TotalExits     int    `json:"total_exits"`
+	TotalAlarms    int    `json:"total_alarms"`
 }
 
 type GateXMLResponse struct {
-	Count0 int `xml:"count0"`
-	Count1 int `xml:"count1"`
-	Count2 int `xml:"count2"`
+	Count0 int `xml:"count0" json:"count0"`
+	Count1 int `xml:"count1" json:"count1"`
+	Count2 int `xml:"count2" json:"count2"`
 }
 
 type App struct {
-	db       *sql.DB
-	gateURLs []string
+	db             *sql.DB
+	table          string
+	gates          []gateSource
+	buildings      []string
+	adminToken     string
+	defaultOrderBy string
+	queryTimeout   time.Duration
+	maxQueryDays   int
+	pollInterval   time.Duration
+	closures       closures
+
+	maintenance             atomic.Bool
+	maintenancePausesPoller bool
+	pollingPaused           atomic.Bool
+	lastEdited              atomic.Int64
+	suspectThreshold        int
+	sinks                   []Sink
+	balanceThresholdPct     float64
+	truncateTimestamps      bool
+	maxExportRows           int
+	pollDBTimeout           time.Duration
+	gateConfig              map[string]GateConfig
+	gateConfigPath          string
+	gateConfigMu            sync.RWMutex
+	alertWebhookURL         string
+	alertOnStuck            bool
+	influxMultiplier        float64
+	influxWindow            int
+	influxMinEntrances      int
+	influxCooldown          time.Duration
+	runningTotals           bool
+	storeUTC                bool
+	timezone                string
+	retentionDays           int
+	buildingCapacity        int
+	decimalPlaces           int
+	minAverageSamples       int
+	skipUnchanged           bool
+	failureMarkers          bool
+	maxBodyBytes            int64
+	pollConcurrency         int
+	csvFilenameTemplate     string
+	openHours               openHours
+	userAgent               string
+	startedAt               time.Time
+
+	// now is the clock used for time-based logic, such as stats windows and
+	// poll scheduling, so tests can freeze it. Durations still use the real
+	// clock.
+	now func() time.Time
+
+	// pollMu serialises poll cycles so an on-demand poll can't interleave
+	// with the scheduled one and double-count diffs.
+	pollMu     sync.Mutex
+	gateStatus gateStatusTracker
+	// activeHours are the open hours inferred for weekdays without OPEN_HOURS
+	activeHours activeHourCache
+	// influxAlerted is when each gate last raised an influx alert
+	influxMu      sync.Mutex
+	influxAlerted map[string]time.Time
 }
 
 var scriptName string
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+const metricsPath = "/metrics"
+
+// pingPath answers reachability checks without touching the database.
+const pingPath = "/ping"
+
+// livezPath serves the same check under the name Kubernetes probes expect.
+const livezPath = "/livez"
+
+// healthPath is where the health check is served, outside SCRIPT_NAME so load
+// balancers can probe it directly. Set from HEALTH_PATH at startup.
+var healthPath = "/health"
+
+// validIdentifier restricts configured SQL identifiers, which cannot be bound
+// as query parameters, to a safe character set.
+var validIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
 func main() {
 	// Setup timezone
-	tz := os.Getenv("TZ")
-	if tz == "" {
-		tz = "America/New_York"
-	}
+	tz := getEnv("TZ", defaultTimezone)
 	location, err := time.LoadLocation(tz)
 	if err != nil {
 		slog.Error("Failed to load timezone", "timezone", tz, "error", err)
@@ -70,6 +167,17 @@ func main() {
 	}))
 	slog.SetDefault(logger)
 	scriptName = os.Getenv("SCRIPT_NAME")
+	healthPath = getEnv("HEALTH_PATH", healthPath)
+	if !strings.HasPrefix(healthPath, "/") {
+		slog.Error("HEALTH_PATH must start with /", "path", healthPath)
+		os.Exit(1)
+	}
+
+	trustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		slog.Error("Failed to parse TRUSTED_PROXIES", "error", err)
+		os.Exit(1)
+	}
 
 	app, err := NewApp()
 	if err != nil {
@@ -80,41 +188,130 @@ func main() {
 
 	// Start background gate counter
 	go app.gateCounterWorker()
+	go app.retentionWorker()
 
 	// Setup routes
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/health", app.handleHealth)
+	mux.HandleFunc(healthPath, app.handleHealth)
+	mux.HandleFunc(metricsPath, app.handleMetrics)
+	mux.HandleFunc(pingPath, handlePing)
+	mux.HandleFunc(livezPath, handlePing)
 
 	mux.HandleFunc(scriptName+"/", app.handleIndex)
 	mux.HandleFunc(scriptName+"/query", app.handleQuery)
+	mux.HandleFunc(scriptName+"/query/explain", app.requireAdmin(app.handleQueryExplain))
 	mux.HandleFunc(scriptName+"/monthly_stats", app.handleMonthlyStats)
+	mux.HandleFunc(scriptName+"/monthly_stats.csv", app.handleMonthlyStats)
 	mux.HandleFunc(scriptName+"/recent_stats", app.handleRecentStats)
 	mux.HandleFunc(scriptName+"/download_csv", app.handleDownloadCSV)
+	mux.HandleFunc(scriptName+"/openapi.json", app.handleOpenAPI)
+	mux.HandleFunc(scriptName+"/trend", app.handleTrend)
+	mux.HandleFunc(scriptName+"/uptime", app.handleUptime)
+	mux.HandleFunc(scriptName+"/gaps", app.handleGaps)
+	mux.HandleFunc(scriptName+"/stats/range", app.handleRangeStats)
+	mux.HandleFunc(scriptName+"/stats/by_gate", app.handleGateSummary)
+	mux.HandleFunc(scriptName+"/gate_share", app.handleGateShare)
+	mux.HandleFunc(scriptName+"/occupancy_series", app.handleOccupancySeries)
+	mux.HandleFunc(scriptName+"/capacity_series", app.handleCapacitySeries)
+	mux.HandleFunc(scriptName+"/balance", app.handleBalance)
+	mux.HandleFunc(scriptName+"/busiest_day", app.handleBusiestDay)
+	mux.HandleFunc(scriptName+"/cumulative", app.handleCumulative)
+	mux.HandleFunc(scriptName+"/normalized_daily", app.handleNormalizedDaily)
+	mux.HandleFunc(scriptName+"/series", app.handleSeries)
+	mux.HandleFunc(scriptName+"/alarm_stats", app.handleAlarmStats)
+	mux.HandleFunc(scriptName+"/raw_counts", app.handleRawCounts)
+	mux.HandleFunc(scriptName+"/recent_readings", app.handleRecentReadings)
+	mux.HandleFunc(scriptName+"/running_totals", app.handleRunningTotals)
+	mux.HandleFunc(scriptName+"/gates", app.handleGates)
+	mux.HandleFunc(scriptName+"/gates/overview", app.handleGatesOverview)
+	mux.HandleFunc(scriptName+"/records/{id}", app.requireAdmin(app.handleRecord))
+	mux.HandleFunc(scriptName+"/admin/backfill", app.requireAdmin(app.handleBackfill))
+	mux.HandleFunc(scriptName+"/admin/gates/{name}", app.requireAdmin(app.handleGateMeta))
+	mux.HandleFunc(scriptName+"/admin/maintenance", app.requireAdmin(app.handleMaintenance))
+	mux.HandleFunc(scriptName+"/admin/pause", app.requireAdmin(app.handlePausePolling))
+	mux.HandleFunc(scriptName+"/admin/resume", app.requireAdmin(app.handleResumePolling))
+	mux.HandleFunc(scriptName+"/admin/poll_now", app.requireAdmin(app.handlePollNow))
+	mux.HandleFunc(scriptName+"/admin/rederive_diffs", app.requireAdmin(app.handleRederiveDiffs))
+	mux.HandleFunc(scriptName+"/admin/rename_gate", app.requireAdmin(app.handleRenameGate))
+
+	if getEnvBool("ENABLE_PPROF", false) {
+		app.registerPprof(mux)
+		slog.Info("Profiling endpoints enabled", "path", "/debug/pprof/")
+	}
 
 	// Apply logging middleware
-	handler := LoggingMiddleware(mux)
+	handler := LoggingMiddleware(camelCaseMiddleware(app.maintenanceMiddleware(app.filterParamsMiddleware(app.maxBodyMiddleware(mux)))))
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	slog.Info("Starting server", "port", port)
-	if err := http.ListenAndServe(":"+port, handler); err != nil {
-		slog.Error("Server failed", "error", err)
-		os.Exit(1)
+	// The write timeout is generous by default because it also bounds how long
+	// a CSV export may stream.
+	srv := &http.Server{
+		Addr:         ":" + port,
+		Handler:      handler,
+		ReadTimeout:  getEnvDuration("HTTP_READ_TIMEOUT", 30*time.Second),
+		WriteTimeout: getEnvDuration("HTTP_WRITE_TIMEOUT", 10*time.Minute),
+		IdleTimeout:  getEnvDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+
+	// Serve TLS directly when both a certificate and key are configured, for
+	// installs without a reverse proxy in front
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (certFile == "") != (keyFile == "") {
+		slog.Warn("Only one of TLS_CERT_FILE and TLS_KEY_FILE is set, serving plain HTTP")
+	}
+	useTLS := certFile != "" && keyFile != ""
+
+	go func() {
+		slog.Info("Starting server", "port", port, "tls", useTLS)
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	stop, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	<-stop.Done()
+
+	// Give in-flight requests, such as a long CSV export, a bounded time to
+	// finish before the rollout forces them closed.
+	drainTimeout := getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+	slog.Info("Shutting down server", "drain_timeout", drainTimeout)
+	ctx, cancelDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancelDrain()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Warn("Drain timed out, closing remaining connections", "error", err)
+		srv.Close()
 	}
 }
 
 func NewApp() (*App, error) {
 	// Database connection
-	dbConfig := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=Local",
-		getEnv("MARIADB_USER", "ole"),
+	// In STORE_UTC mode the driver writes and reads DATETIME values as UTC;
+	// otherwise they hold local wall-clock time as they always have.
+	storeUTC := getEnvBool("STORE_UTC", false)
+	dbLoc := "Local"
+	if storeUTC {
+		dbLoc = "UTC"
+	}
+	dbConfig := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true&loc=%s",
+		getEnvOrFile("MARIADB_USER", "ole"),
 		getDBPassword(),
-		getEnv("MARIADB_HOST", "mariadb"),
-		getEnv("MARIADB_PORT", "3306"),
-		getEnv("MARIADB_NAME", "ole"),
+		getEnvOrFile("MARIADB_HOST", "mariadb"),
+		getEnvOrFile("MARIADB_PORT", "3306"),
+		getEnvOrFile("MARIADB_NAME", "ole"),
+		dbLoc,
 	)
 
 	db, err := sql.Open("mysql", dbConfig)
@@ -126,20 +323,124 @@ func NewApp() (*App, error) {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
-	// Gate URLs
-	gateURLsStr := os.Getenv("OLE_GATE_URLS")
-	var gateURLs []string
-	if gateURLsStr != "" {
-		gateURLs = strings.Split(gateURLsStr, ",")
-		for i, url := range gateURLs {
-			gateURLs[i] = strings.TrimSpace(url)
+	if tz := getEnv("TZ", defaultTimezone); storeUTC {
+		if !validTimezone.MatchString(tz) {
+			return nil, fmt.Errorf("invalid TZ %q for STORE_UTC", tz)
 		}
+		if err := checkTimezoneTables(db, tz); err != nil {
+			return nil, err
+		}
+	}
+
+	table := getEnv("GATE_COUNTS_TABLE", defaultCountsTable)
+	if !validIdentifier.MatchString(table) || !validIdentifier.MatchString(companionTable(table, "baseline")) {
+		return nil, fmt.Errorf("invalid GATE_COUNTS_TABLE %q", table)
+	}
+
+	if err := migrate(db, table); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	if err := validateSchema(db, table); err != nil {
+		return nil, fmt.Errorf("unexpected database schema: %w", err)
 	}
 
-	return &App{
-		db:       db,
-		gateURLs: gateURLs,
-	}, nil
+	gatePrefix := strings.Trim(os.Getenv("GATE_PREFIX"), "/ ")
+	gates, buildings, err := parseGateSources(os.Getenv("BUILDINGS"), os.Getenv("OLE_GATE_URLS"), gatePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultOrderBy, err := parseOrderBy(getEnv("DEFAULT_ORDER_BY", "asc"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid DEFAULT_ORDER_BY: %w", err)
+	}
+
+	closures, err := parseClosures(os.Getenv("CLOSURES"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLOSURES: %w", err)
+	}
+
+	hours, err := parseOpenHours(os.Getenv("OPEN_HOURS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OPEN_HOURS: %w", err)
+	}
+
+	gateConfigPath := os.Getenv("GATE_CONFIG")
+	gateConfig, err := loadGateConfig(gateConfigPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GATE_CONFIG: %w", err)
+	}
+
+	influxWindow := getEnvInt("INFLUX_ALERT_WINDOW", 8)
+	if influxWindow < 1 {
+		return nil, fmt.Errorf("invalid INFLUX_ALERT_WINDOW %d: must be at least 1", influxWindow)
+	}
+
+	app := &App{
+		db:             db,
+		table:          table,
+		gates:          gates,
+		buildings:      buildings,
+		adminToken:     getAdminToken(),
+		defaultOrderBy: defaultOrderBy,
+		queryTimeout:   getEnvDuration("QUERY_TIMEOUT", 30*time.Second),
+		maxQueryDays:   getEnvInt("MAX_QUERY_DAYS", 366),
+		pollInterval:   getEnvDuration("POLL_INTERVAL", time.Hour),
+		closures:       closures,
+
+		maintenancePausesPoller: getEnvBool("MAINTENANCE_PAUSES_POLLING", true),
+		suspectThreshold:        getEnvInt("SUSPECT_DIFF_THRESHOLD", 5000),
+		balanceThresholdPct:     float64(getEnvInt("BALANCE_THRESHOLD_PCT", 10)),
+		truncateTimestamps:      getEnvBool("TRUNCATE_TIMESTAMPS", false),
+		maxExportRows:           getEnvInt("MAX_EXPORT_ROWS", 500000),
+		pollDBTimeout:           getEnvDuration("POLL_DB_TIMEOUT", 10*time.Second),
+		gateConfig:              gateConfig,
+		gateConfigPath:          gateConfigPath,
+		alertWebhookURL:         os.Getenv("ALERT_WEBHOOK_URL"),
+		alertOnStuck:            getEnvBool("ALERT_ON_STUCK_GATES", false),
+		influxMultiplier:        getEnvFloat("INFLUX_ALERT_MULTIPLIER", 0),
+		influxWindow:            influxWindow,
+		influxCooldown:          getEnvDuration("INFLUX_ALERT_COOLDOWN", time.Hour),
+		influxMinEntrances:      getEnvInt("INFLUX_ALERT_MIN_ENTRANCES", 25),
+		runningTotals:           getEnvBool("RUNNING_TOTALS", false),
+		storeUTC:                storeUTC,
+		timezone:                getEnv("TZ", defaultTimezone),
+		retentionDays:           getEnvInt("RETENTION_DAYS", 0),
+		buildingCapacity:        getEnvInt("BUILDING_CAPACITY", 0),
+		decimalPlaces:           getEnvInt("DECIMAL_PLACES", 2),
+		minAverageSamples:       getEnvInt("MIN_AVERAGE_SAMPLES", 3),
+		skipUnchanged:           getEnvBool("SKIP_UNCHANGED_READINGS", false),
+		failureMarkers:          getEnvBool("FAILURE_MARKERS", false),
+		maxBodyBytes:            int64(getEnvInt("MAX_REQUEST_BODY_BYTES", 1<<20)),
+		pollConcurrency:         getEnvInt("POLL_CONCURRENCY", 4),
+		csvFilenameTemplate:     getEnv("CSV_FILENAME", "gate_counts_{timestamp}.csv"),
+		openHours:               hours,
+		userAgent:               getEnv("GATE_USER_AGENT", "ole-gate-count/"+version),
+		startedAt:               time.Now(),
+		now:                     time.Now,
+	}
+	app.maintenance.Store(getEnvBool("MAINTENANCE_MODE", false))
+	app.markEdited()
+	app.gateStatus.downAfter = getEnvInt("GATE_DOWN_AFTER_FAILURES", 3)
+	app.gateStatus.stuckAfter = getEnvInt("GATE_STUCK_AFTER_CYCLES", 4)
+
+	app.sinks = []Sink{&dbSink{app: app}}
+	if dir := os.Getenv("FILE_SINK_DIR"); dir != "" {
+		fs, err := newFileSink(dir)
+		if err != nil {
+			return nil, err
+		}
+		app.sinks = append(app.sinks, fs)
+	}
+	if app.runningTotals {
+		if err := seedRunningTotals(db, table); err != nil {
+			return nil, fmt.Errorf("failed to seed running totals: %w", err)
+		}
+		app.sinks = append(app.sinks, &totalsSink{app: app})
+	}
+
+	return app, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -149,25 +450,115 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvOrFile is getEnv for settings that may come from a mounted secret:
+// if KEY_FILE is set, the value is read from that file instead.
+func getEnvOrFile(key, defaultValue string) string {
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		slog.Warn("Failed to read secret file, falling back to environment", "key", key+"_FILE", "path", path, "error", err)
+	}
+	return getEnv(key, defaultValue)
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return i
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("Invalid number in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		slog.Warn("Invalid duration in environment, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
 func getDBPassword() string {
+	if path := os.Getenv("MARIADB_PASSWORD_FILE"); path != "" {
+		if data, err := os.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+		slog.Warn("Failed to read secret file", "key", "MARIADB_PASSWORD_FILE", "path", path)
+	}
 	if data, err := os.ReadFile("/var/run/secrets/OLE_DB_PASSWORD"); err == nil {
 		return strings.TrimSpace(string(data))
 	}
 	return "password"
 }
 
+// handlePing reports that the server is up. Unlike the health check it never
+// queries the database, so monitors can call it as often as they like.
+func handlePing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
 func (app *App) handleHealth(w http.ResponseWriter, r *http.Request) {
+	// Maintenance usually means the database is being migrated or restored,
+	// so report that without touching it
+	if app.maintenance.Load() {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":         "maintenance",
+			"service":        "ole-gate-count",
+			"maintenance":    true,
+			"polling_paused": app.pollingPaused.Load(),
+		})
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
 	// Check database connection
-	if err := app.db.Ping(); err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+	if err := app.db.PingContext(ctx); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
 			"status":   "unhealthy",
 			"service":  "ole-gate-count",
 			"database": "disconnected",
 			"error":    err.Error(),
-		}); err != nil {
-			slog.Error("Failed to encode JSON response", "error", err)
-		}
+		})
 		return
 	}
 
@@ -175,41 +566,40 @@ func (app *App) handleHealth(w http.ResponseWriter, r *http.Request) {
 	var count int
 	var latestEntry sql.NullTime
 
-	recentThreshold := time.Now().Add(-90 * time.Minute)
-	err := app.db.QueryRow(`
+	recentThreshold := app.now().Add(-90 * time.Minute)
+	err := app.db.QueryRowContext(ctx, `
 		SELECT COUNT(*) as recent_count, MAX(timestamp) as latest_entry
-		FROM lib_gate_counts
-		WHERE timestamp >= ?
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND failed = 0
 	`, recentThreshold).Scan(&count, &latestEntry)
 
 	if err != nil {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
 			"status":   "unhealthy",
 			"service":  "ole-gate-count",
 			"database": "error",
 			"error":    err.Error(),
-		}); err != nil {
-			slog.Error("Failed to encode JSON response", "error", err)
-		}
+		})
 		return
 	}
 
 	status := "healthy"
 	httpStatus := http.StatusOK
-	if count == 0 {
+	if count == 0 && !(app.skipUnchanged && app.gateStatus.succeededSince(recentThreshold)) {
 		status = "degraded"
 		httpStatus = http.StatusServiceUnavailable
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(httpStatus)
-
 	response := map[string]interface{}{
 		"status":         status,
 		"service":        "ole-gate-count",
 		"database":       "connected",
 		"recent_entries": count,
+		"maintenance":    app.maintenance.Load(),
+		"polling_paused": app.pollingPaused.Load(),
+		"gates":          app.gateStatus.snapshot(),
+		"down_gates":     app.gateStatus.down(),
+		"stuck_gates":    app.gateStatus.stuck(),
 	}
 
 	if latestEntry.Valid {
@@ -218,14 +608,34 @@ func (app *App) handleHealth(w http.ResponseWriter, r *http.Request) {
 		response["latest_entry"] = nil
 	}
 
-	if err := json.NewEncoder(w).Encode(response); err != nil {
-		slog.Error("Failed to encode JSON response", "error", err)
+	if verbose, _ := strconv.ParseBool(r.URL.Query().Get("verbose")); verbose {
+		gates, err := app.gateHealth(ctx)
+		if err != nil {
+			slog.Warn("Failed to load per-gate health", "error", err)
+		} else {
+			response["gates"] = gates
+		}
+		response["poll_interval"] = app.pollInterval.String()
+		response["uptime_seconds"] = int(time.Since(app.startedAt).Seconds())
+		response["db_stats"] = dbStats(app.db.Stats())
 	}
+
+	writeJSON(w, httpStatus, response)
+}
+
+type gateFreshness struct {
+	Name          string
+	LatestReading time.Time
+	Stale         bool
 }
 
 func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
-	// Get unique gate names
-	rows, err := app.db.Query("SELECT DISTINCT gate_name FROM lib_gate_counts ORDER BY gate_name")
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	// Get unique gate names along with when each last reported
+	// Unchanged polls skipped by SKIP_UNCHANGED_READINGS still count as reports
+	rows, err := app.db.QueryContext(ctx, "SELECT gate_name, MAX(COALESCE(polled_through, timestamp)) FROM "+app.table+" GROUP BY gate_name ORDER BY gate_name")
 	if err != nil {
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		slog.Error("Failed to get gate names", "error", err)
@@ -233,14 +643,28 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
+	// A gate is stale once it has missed more than one poll
+	staleBefore := app.now().Add(-2 * app.pollInterval)
+
 	var gateNames []string
+	var gates []gateFreshness
+	var latestReading time.Time
 	for rows.Next() {
 		var gateName string
-		if err := rows.Scan(&gateName); err != nil {
+		var latest sql.NullTime
+		if err := rows.Scan(&gateName, &latest); err != nil {
 			slog.Error("Failed to scan gate name", "error", err)
 			continue
 		}
 		gateNames = append(gateNames, gateName)
+		gates = append(gates, gateFreshness{
+			Name:          gateName,
+			LatestReading: latest.Time,
+			Stale:         !latest.Valid || latest.Time.Before(staleBefore),
+		})
+		if latest.Time.After(latestReading) {
+			latestReading = latest.Time
+		}
 	}
 
 	t, err := template.ParseFiles("templates/index.html")
@@ -251,11 +675,15 @@ func (app *App) handleIndex(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		GateNames  []string
-		ScriptName string
+		GateNames     []string
+		Gates         []gateFreshness
+		LatestReading time.Time
+		ScriptName    string
 	}{
-		GateNames:  gateNames,
-		ScriptName: scriptName,
+		GateNames:     gateNames,
+		Gates:         gates,
+		LatestReading: latestReading,
+		ScriptName:    scriptName,
 	}
 
 	w.Header().Set("Content-Type", "text/html")
@@ -270,38 +698,57 @@ func (app *App) handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		GateName  string `json:"gate_name"`
-		StartDate string `json:"start_date"`
-		EndDate   string `json:"end_date"`
-		OrderBy   string `json:"order_by"`
-	}
+	var req QueryFilter
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	results, err := app.queryGateCounts(req.GateName, req.StartDate, req.EndDate, req.OrderBy)
+	if err := app.validateFilter(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	w.Header().Set("Vary", "Accept")
+	switch format := r.URL.Query().Get("format"); {
+	case format == "jsonl" || (format == "" && strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")):
+		app.writeJSONL(ctx, w, req)
+		return
+	case format == "csv" || (format == "" && prefersCSV(r.Header.Get("Accept"))):
+		app.writeCSV(ctx, w, req)
+		return
+	}
+
+	results, truncated, err := app.queryGateCounts(ctx, req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		}); err != nil {
-			slog.Error("Failed to encode JSON response", "error", err)
-		}
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"data":    results,
-		"count":   len(results),
-	}); err != nil {
-		slog.Error("Failed to encode JSON response", "error", err)
+	var latest time.Time
+	for _, gc := range results {
+		if gc.Timestamp.After(latest) {
+			latest = gc.Timestamp
+		}
+	}
+	setLastModified(w, latest)
+
+	if truncated {
+		w.Header().Set("X-Truncated", "true")
 	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"data":      results,
+		"count":     len(results),
+		"truncated": truncated,
+	})
 }
 
 func (app *App) handleDownloadCSV(w http.ResponseWriter, r *http.Request) {
@@ -310,51 +757,25 @@ func (app *App) handleDownloadCSV(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		GateName  string `json:"gate_name"`
-		StartDate string `json:"start_date"`
-		EndDate   string `json:"end_date"`
-		OrderBy   string `json:"order_by"`
-	}
+	var req QueryFilter
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
 
-	results, err := app.queryGateCounts(req.GateName, req.StartDate, req.EndDate, req.OrderBy)
-	if err != nil {
-		http.Error(w, "Query failed", http.StatusInternalServerError)
+	if err := app.validateFilter(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	filename := fmt.Sprintf("gate_counts_%s.csv", time.Now().Format("20060102_150405"))
-	w.Header().Set("Content-Type", "text/csv")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-
-	// Write CSV header
-	if _, err := w.Write([]byte("timestamp,gate_name,alarm_count,alarm_diff,incoming_patrons_count,incoming_diff,outgoing_patrons_count,outgoing_diff\n")); err != nil {
-		slog.Error("Failed to write CSV header", "error", err)
-		return
-	}
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
 
-	// Write CSV data
-	for _, record := range results {
-		line := fmt.Sprintf("%s,%s,%d,%d,%d,%d,%d,%d\n",
-			record.Timestamp.Format("2006-01-02 15:04:05"),
-			record.GateName,
-			record.AlarmCount,
-			record.AlarmDiff,
-			record.IncomingPatronsCount,
-			record.IncomingDiff,
-			record.OutgoingPatronsCount,
-			record.OutgoingDiff,
-		)
-		if _, err := w.Write([]byte(line)); err != nil {
-			slog.Error("Failed to write CSV line", "error", err)
-			return
-		}
-	}
+	app.writeCSV(ctx, w, req)
 }
 
 func (app *App) handleMonthlyStats(w http.ResponseWriter, r *http.Request) {
@@ -364,49 +785,89 @@ func (app *App) handleMonthlyStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the past year of monthly entrance data
-	oneYearAgo := time.Now().AddDate(-1, 0, 0)
+	oneYearAgo := app.now().AddDate(-1, 0, 0)
+
+	metric := r.URL.Query().Get("metric")
+	switch metric {
+	case "":
+		metric = "entrances"
+	case "entrances", "entrances,exits", "exits", "both", "net":
+	default:
+		writeError(w, http.StatusBadRequest, `metric must be one of "entrances", "entrances,exits", "exits", "both" or "net"`)
+		return
+	}
 
+	filter, filterArgs := statsFilter(r)
+	args := append([]any{oneYearAgo}, filterArgs...)
 	query := `
 		SELECT 
-			CONCAT(YEAR(timestamp), "-", LPAD(MONTH(timestamp), 2, '0')) as month,
-			SUM(incoming_diff) as total_entrances
-		FROM lib_gate_counts 
-		WHERE timestamp >= ? AND incoming_diff > 0
-		GROUP BY YEAR(timestamp), MONTH(timestamp)
-		ORDER BY YEAR(timestamp), MONTH(timestamp)
+			DATE_FORMAT(` + app.localTimestamp() + `, '%Y-%m') as month,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0) as total_entrances,
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0) as total_exits
+		FROM ` + app.table + ` 
+		WHERE timestamp >= ?` + filter + `
+		GROUP BY month
+		ORDER BY month
 	`
 
-	rows, err := app.db.Query(query, oneYearAgo)
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	// No conditional GET here: the window moves with the clock, so the
+	// response changes as old readings fall out of it even when none are added.
+	rows, err := app.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		}); err != nil {
-			slog.Error("Failed to encode JSON response", "error", err)
-		}
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 	defer rows.Close()
 
-	var results []MonthlyStats
+	results := []MonthlyStats{}
 	for rows.Next() {
-		var stat MonthlyStats
-		err := rows.Scan(&stat.Month, &stat.Entrances)
-		if err != nil {
-			slog.Error("Failed to scan monthly stats", "error", err)
-			continue
+		var month string
+		var entrances, exits int
+		if err := rows.Scan(&month, &entrances, &exits); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
 		}
-		results = append(results, stat)
+		results = append(results, newMonthlyStats(metric, month, entrances, exits))
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, ".csv") || r.URL.Query().Get("format") == "csv" {
+		app.writeMonthlyStatsCSV(w, metric, results)
+		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"data":    results,
-	}); err != nil {
-		slog.Error("Failed to encode JSON response", "error", err)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"has_data": len(results) > 0,
+		"data":     results,
+	})
+}
+
+// newMonthlyStats fills in the fields the requested metric reports:
+// entrances or entrances,exits (entrances and exits, so one request serves
+// both trend charts), exits, both (entrances, exits and their total) or net
+// (entrances, exits and entrances minus exits).
+func newMonthlyStats(metric, month string, entrances, exits int) MonthlyStats {
+	stat := MonthlyStats{Month: month}
+	switch metric {
+	case "exits":
+		stat.Exits = &exits
+	case "both":
+		total := entrances + exits
+		stat.Entrances, stat.Exits, stat.Total = &entrances, &exits, &total
+	case "net":
+		net := entrances - exits
+		stat.Entrances, stat.Exits, stat.Net = &entrances, &exits, &net
+	default:
+		stat.Entrances, stat.Exits = &entrances, &exits
 	}
+	return stat
 }
 
 func (app *App) handleRecentStats(w http.ResponseWriter, r *http.Request) {
@@ -416,163 +877,418 @@ func (app *App) handleRecentStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the past 3 hours of data
-	threeHoursAgo := time.Now().Add(-3 * time.Hour)
+	threeHoursAgo := app.now().Add(-3 * time.Hour)
 
+	filter, filterArgs := statsFilter(r)
 	query := `
 		SELECT 
 			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0) as total_entrances,
-			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0) as total_exits
-		FROM lib_gate_counts 
-		WHERE timestamp >= ?
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0) as total_exits,
+			COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0) as total_alarms,
+			COUNT(*) as readings
+		FROM ` + app.table + ` 
+		WHERE timestamp >= ?` + filter + `
 	`
 
 	var stats RecentStats
-	err := app.db.QueryRow(query, threeHoursAgo).Scan(&stats.TotalEntrances, &stats.TotalExits)
+	var readings int
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	err := app.db.QueryRowContext(ctx, query, append([]any{threeHoursAgo}, filterArgs...)...).Scan(&stats.TotalEntrances, &stats.TotalExits, &stats.TotalAlarms, &readings)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		if err := json.NewEncoder(w).Encode(map[string]interface{}{
-			"success": false,
-			"error":   err.Error(),
-		}); err != nil {
-			slog.Error("Failed to encode JSON response", "error", err)
-		}
+		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"data":    stats,
-	}); err != nil {
-		slog.Error("Failed to encode JSON response", "error", err)
+	if byGate, _ := strconv.ParseBool(r.URL.Query().Get("by_gate")); byGate {
+		stats.Gates, err = app.recentStatsByGate(ctx, threeHoursAgo, filter, filterArgs)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
 	}
-}
 
-func (app *App) queryGateCounts(gateName, startDate, endDate, orderBy string) ([]GateCount, error) {
-	query := "SELECT timestamp, gate_name, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff FROM lib_gate_counts WHERE 1=1"
-	args := []interface{}{}
+	// Zero totals alone can't tell a quiet building from one with no readings
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"has_data": readings > 0,
+		"data":     stats,
+	})
+}
 
-	if gateName != "" && gateName != "all" {
-		query += " AND gate_name LIKE ?"
-		args = append(args, "%"+gateName+"%")
+// checkDateRange rejects malformed dates and, unless allowLarge is set, ranges
+// spanning more than the configured maximum. A missing start date is an
+// unbounded range.
+func (app *App) checkDateRange(startDate, endDate string, allowLarge bool) error {
+	var start, end time.Time
+	var err error
+	if startDate != "" {
+		if start, err = time.ParseInLocation(dateLayout, startDate, time.Local); err != nil {
+			return fmt.Errorf("invalid start_date %q: expected YYYY-MM-DD", startDate)
+		}
+	}
+	end = app.now()
+	if endDate != "" {
+		if end, err = time.ParseInLocation(dateLayout, endDate, time.Local); err != nil {
+			return fmt.Errorf("invalid end_date %q: expected YYYY-MM-DD", endDate)
+		}
 	}
 
-	if startDate != "" {
-		query += " AND timestamp >= ?"
-		args = append(args, startDate+" 00:00:00")
+	if allowLarge || app.maxQueryDays <= 0 {
+		return nil
 	}
+	if start.IsZero() || end.Sub(start) > time.Duration(app.maxQueryDays)*24*time.Hour {
+		return fmt.Errorf("date range exceeds the maximum of %d days; narrow the range or set allow_large=true", app.maxQueryDays)
+	}
+	return nil
+}
 
-	if endDate != "" {
-		query += " AND timestamp <= ?"
-		args = append(args, endDate+" 23:59:59")
+// queryContext bounds the database work done for a request by the configured
+// query timeout. The request's context is the parent, so a client that
+// disconnects also cancels its queries.
+func (app *App) queryContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), app.queryTimeout)
+}
+
+func parseOrderBy(orderBy string) (string, error) {
+	switch o := strings.ToLower(strings.TrimSpace(orderBy)); o {
+	case "asc", "desc":
+		return o, nil
+	default:
+		return "", fmt.Errorf("invalid order_by %q: must be \"asc\" or \"desc\"", orderBy)
 	}
+}
 
-	// Add order by clause
-	if orderBy == "desc" {
-		query += " ORDER BY timestamp DESC"
-	} else {
-		query += " ORDER BY timestamp ASC"
+// resolveOrderBy validates a requested sort order, falling back to the
+// configured default when none was given.
+func (app *App) resolveOrderBy(orderBy string) (string, error) {
+	if orderBy == "" {
+		return app.defaultOrderBy, nil
 	}
+	return parseOrderBy(orderBy)
+}
 
-	rows, err := app.db.Query(query, args...)
+func (app *App) recentStatsByGate(ctx context.Context, since time.Time, filter string, filterArgs []any) ([]GateRecentStats, error) {
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT gate_name,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0)
+		FROM `+app.table+`
+		WHERE timestamp >= ?`+filter+`
+		GROUP BY gate_name
+		ORDER BY gate_name
+	`, append([]any{since}, filterArgs...)...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var results []GateCount
+	gates := []GateRecentStats{}
 	for rows.Next() {
-		var gc GateCount
-		err := rows.Scan(&gc.Timestamp, &gc.GateName, &gc.AlarmCount, &gc.AlarmDiff,
-			&gc.IncomingPatronsCount, &gc.IncomingDiff, &gc.OutgoingPatronsCount, &gc.OutgoingDiff)
-		if err != nil {
+		var g GateRecentStats
+		if err := rows.Scan(&g.GateName, &g.TotalEntrances, &g.TotalExits, &g.TotalAlarms); err != nil {
 			return nil, err
 		}
+		gates = append(gates, g)
+	}
+	return gates, rows.Err()
+}
+
+func (app *App) queryGateCounts(ctx context.Context, filter QueryFilter) ([]GateCount, bool, error) {
+	rows, err := app.queryGateCountRows(ctx, filter, true)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	results := []GateCount{}
+	for rows.Next() {
+		gc, err := scanGateCount(rows)
+		if err != nil {
+			return nil, false, err
+		}
 		results = append(results, gc)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	// One row past the cap is fetched so a result of exactly maxExportRows is
+	// not reported as truncated.
+	if app.maxExportRows > 0 && len(results) > app.maxExportRows {
+		return results[:app.maxExportRows], true, nil
+	}
+	return results, false, nil
+}
+
+// queryGateCountRows runs the filtered gate count query and leaves iterating
+// the cursor to the caller so large exports can be streamed. Results are
+// limited to MAX_EXPORT_ROWS, plus one extra row when probe is set so the
+// caller can tell the cap was hit.
+func (app *App) queryGateCountRows(ctx context.Context, filter QueryFilter, probe bool) (*sql.Rows, error) {
+	query, args := app.gateCountsQuery(filter, probe)
+	return app.db.QueryContext(ctx, query, args...)
+}
+
+// gateCountsQuery returns the SQL and bound arguments queryGateCountRows runs
+// for a filter.
+func (app *App) gateCountsQuery(filter QueryFilter, probe bool) (string, []any) {
+	where, args := filter.Build()
+	query := "SELECT " + gateCountColumns + " FROM " + app.table + where + filter.orderClause()
+	if app.maxExportRows > 0 {
+		limit := app.maxExportRows
+		if probe {
+			limit++
+		}
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	return query, args
+}
 
-	return results, nil
+// exceedsExportCap reports whether more rows match the filter than
+// MAX_EXPORT_ROWS allows. Streaming exports check this up front because the
+// response headers are sent before the rows.
+func (app *App) exceedsExportCap(ctx context.Context, filter QueryFilter) (bool, error) {
+	if app.maxExportRows <= 0 {
+		return false, nil
+	}
+	where, args := filter.Build()
+	var n int
+	err := app.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM (SELECT 1 FROM "+app.table+where+" LIMIT "+strconv.Itoa(app.maxExportRows+1)+") capped",
+		args...,
+	).Scan(&n)
+	return n > app.maxExportRows, err
 }
 
 func (app *App) gateCounterWorker() {
-	if len(app.gateURLs) == 0 {
+	if len(app.gates) == 0 {
 		slog.Info("No gate URLs configured, gate counting disabled")
 		return
 	}
 
-	slog.Info("Starting gate counter worker", "gates", len(app.gateURLs))
+	slog.Info("Starting gate counter worker", "gates", len(app.gates), "buildings", len(app.buildings), "interval", app.pollInterval)
 
 	for {
-		now := time.Now()
-		// Calculate seconds until next hour
-		nextHour := now.Truncate(time.Hour).Add(time.Hour)
-		waitTime := nextHour.Sub(now)
+		now := app.now()
+		next, due := app.nextDueGates(now)
+		waitTime := next.Sub(now)
 
-		slog.Info("Waiting until top of the hour", "wait_seconds", int(waitTime.Seconds()))
+		slog.Info("Waiting until next poll", "wait_seconds", int(waitTime.Seconds()), "gates", len(due))
 		time.Sleep(waitTime)
 
-		if err := app.recordGateCounts(); err != nil {
-			slog.Error("Failed to record gate counts", "error", err)
+		if app.maintenance.Load() && app.maintenancePausesPoller {
+			slog.Info("Maintenance mode enabled, skipping poll")
+			continue
 		}
+		if app.pollingPaused.Load() {
+			slog.Info("Polling paused, skipping poll")
+			continue
+		}
+
+		app.recordGateCounts(due)
 	}
 }
 
-func (app *App) recordGateCounts() error {
-	slog.Info("Recording gate counts")
-
-	for i, url := range app.gateURLs {
-		gateName := app.getGateName(url, i)
-		if err := app.updateGateCount(url, gateName); err != nil {
-			slog.Error("Failed to update gate count", "gate", gateName, "error", err)
+// nextDueGates returns the earliest upcoming poll boundary across every
+// gate's own interval and the gates due then, so a gate configured to poll
+// every few hours is left alone on the hourly cycles in between.
+func (app *App) nextDueGates(now time.Time) (time.Time, []gateSource) {
+	var next time.Time
+	var due []gateSource
+	for _, gate := range app.gates {
+		t := app.nextPollTime(now, app.gateInterval(getGateName(gate)))
+		switch {
+		case next.IsZero() || t.Before(next):
+			next, due = t, []gateSource{gate}
+		case t.Equal(next):
+			due = append(due, gate)
 		}
 	}
+	return next, due
+}
 
-	slog.Info("Gate counting completed successfully")
-	return nil
+// nextPollTime returns the next boundary of interval after now. Boundaries are
+// counted on the local wall clock from midnight when the interval divides a
+// day, so across a DST change polls still land on the hour: in spring the
+// missing hour's boundary becomes the first one after the jump, and in fall
+// the repeated hour is skipped rather than recorded twice under the same
+// local timestamp. In STORE_UTC mode timestamps can't collide, so boundaries
+// are counted in UTC.
+func (app *App) nextPollTime(now time.Time, interval time.Duration) time.Time {
+	fallback := now.Truncate(interval).Add(interval)
+	if interval <= 0 || 24*time.Hour%interval != 0 {
+		return fallback
+	}
+
+	loc := time.Local
+	if app.storeUTC {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+	y, m, d := local.Date()
+	wall := time.Duration(local.Hour())*time.Hour +
+		time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second +
+		time.Duration(local.Nanosecond())
+	boundary := (wall/interval + 1) * interval
+
+	// time.Date normalizes a wall-clock time that falls in a spring-forward
+	// gap to the instant just after it.
+	next := time.Date(y, m, d, 0, 0, 0, int(boundary), loc)
+	if !next.After(now) {
+		return fallback
+	}
+	return next
 }
 
-func (app *App) getGateName(url string, index int) string {
-	urlLower := strings.ToLower(url)
+type PollResult struct {
+	GateName  string `json:"gate_name"`
+	Success   bool   `json:"success"`
+	Error     string `json:"error,omitempty"`
+	Entrances int    `json:"entrances"`
+	Exits     int    `json:"exits"`
+}
+
+func (app *App) recordGateCounts(gates []gateSource) []PollResult {
+	app.pollMu.Lock()
+	defer app.pollMu.Unlock()
+
+	slog.Info("Recording gate counts", "gates", len(gates))
+	started := time.Now()
+
+	// Bound the whole cycle by the shortest interval polled so a wedged gate
+	// or database can't hold the worker past the next scheduled poll.
+	timeout := app.pollInterval
+	for _, gate := range gates {
+		timeout = min(timeout, app.gateInterval(getGateName(gate)))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Poll gates in parallel, but no more than POLL_CONCURRENCY at once so a
+	// large install doesn't open a connection to every gate simultaneously.
+	results := make([]PollResult, len(gates))
+	sem := make(chan struct{}, max(app.pollConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, gate := range gates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			gateName, building := app.pollName(gate)
+			result := PollResult{GateName: gateName, Success: true}
+			gc, err := app.updateGateCount(ctx, gate.URL, building, gateName)
+			if err != nil {
+				slog.Error("Failed to update gate count", "gate", gateName, "error", err)
+				result.Success = false
+				result.Error = err.Error()
+				if app.failureMarkers {
+					app.insertFailureMarker(building, gateName)
+				}
+			}
+			result.Entrances, result.Exits = max(gc.IncomingDiff, 0), max(gc.OutgoingDiff, 0)
+			results[i] = result
+		}()
+	}
+	wg.Wait()
+
+	// One line per cycle so "how did the 3pm poll go" is a single grep
+	succeeded, entrances, exits := 0, 0, 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+		entrances += result.Entrances
+		exits += result.Exits
+	}
+	slog.Info("Gate counting completed",
+		"attempted", len(results),
+		"succeeded", succeeded,
+		"failed", len(results)-succeeded,
+		"entrances", entrances,
+		"exits", exits,
+		"duration", time.Since(started).Round(time.Millisecond),
+	)
+	return results
+}
+
+func getGateName(gate gateSource) string {
+	name := fmt.Sprintf("Gate %d", gate.Index+1)
+	urlLower := strings.ToLower(gate.URL)
 	if strings.Contains(urlLower, "south") {
-		return "FM South gate"
+		name = "FM South gate"
 	} else if strings.Contains(urlLower, "west") {
-		return "FM West gate"
+		name = "FM West gate"
+	}
+
+	// Namespace derived names by building so gates sharing a database don't
+	// collide
+	if gate.Building != "" {
+		name = gate.Building + "/" + name
 	}
-	return fmt.Sprintf("Gate %d", index+1)
+	return name
 }
 
-func (app *App) updateGateCount(gateURL, gateName string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+func (app *App) updateGateCount(ctx context.Context, gateURL, building, gateName string) (gc GateCount, err error) {
+	started := time.Now()
+	defer func() { app.gateStatus.record(gateName, err, app.now(), time.Since(started)) }()
+
+	fetchCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", gateURL, nil)
+	body, err := app.fetchGateBody(fetchCtx, gateURL)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return GateCount{}, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// A successful fetch that isn't counter data (often a login or error page)
+	// is a config or auth problem rather than a network blip. A gate caught
+	// mid-restart can serve a partial page too, so it's fetched once more
+	// before being alerted separately with enough of the body to tell what
+	// the gate sent back.
+	xmlResp, err := parseGateBody(body)
 	if err != nil {
-		return fmt.Errorf("failed to fetch gate data: %w", err)
+		slog.Warn("Gate response isn't counter data, retrying", "gate", gateName, "error", err)
+		select {
+		case <-fetchCtx.Done():
+		case <-time.After(decodeRetryDelay):
+			if retryBody, fetchErr := app.fetchGateBody(fetchCtx, gateURL); fetchErr == nil {
+				body = retryBody
+				xmlResp, err = parseGateBody(body)
+			}
+		}
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad response from %s: %d", gateURL, resp.StatusCode)
+	if err != nil {
+		prefix := string(body[:min(len(body), 200)])
+		app.alert(Alert{
+			Kind:     "decode_failure",
+			GateName: gateName,
+			Message:  "Gate returned a response that isn't counter XML; check its URL and credentials",
+			Detail:   prefix,
+		})
+		return GateCount{}, fmt.Errorf("failed to decode gate response (body starts %q): %w", prefix, err)
 	}
 
-	var xmlResp GateXMLResponse
-	if err := xml.NewDecoder(resp.Body).Decode(&xmlResp); err != nil {
-		return fmt.Errorf("failed to decode XML: %w", err)
-	}
+	// Get current counts, honouring any per-gate wiring override
+	counts := [3]int{xmlResp.Count0, xmlResp.Count1, xmlResp.Count2}
+	mapping := app.countMapping(gateName)
+	alarmCount := counts[mapping.Alarm]
+	incoming := counts[mapping.Incoming]
+	outgoing := counts[mapping.Outgoing]
 
-	// Get current counts
-	alarmCount := xmlResp.Count0
-	incoming := xmlResp.Count1
-	outgoing := xmlResp.Count2
+	// The lookup and insert share one deadline so a slow database fails this
+	// gate rather than stalling the cycle.
+	dbCtx, dbCancel := context.WithTimeout(ctx, app.pollDBTimeout)
+	defer dbCancel()
 
 	// Calculate diffs
 	alarmDiff, incomingDiff, outgoingDiff := 0, 0, 0
-	last, err := app.getLastCount(gateName)
+	last, err := app.getLastCount(dbCtx, building, gateName)
 	if err != nil {
 		slog.Warn("Failed to get last count", "gate", gateName, "error", err)
 	} else if last != nil {
@@ -581,10 +1297,86 @@ func (app *App) updateGateCount(gateURL, gateName string) error {
 		outgoingDiff = outgoing - last.OutgoingPatronsCount
 	}
 
-	// Insert new count
-	timestamp := time.Now()
-	if err := app.insertCount(timestamp, gateName, alarmCount, alarmDiff, incoming, incomingDiff, outgoing, outgoingDiff); err != nil {
-		return fmt.Errorf("failed to insert count: %w", err)
+	// Identical counts are normal while the library is closed, so only polls
+	// during open hours count towards marking the sensor frozen. Weekdays
+	// without OPEN_HOURS use the hours that usually see entrances.
+	unchanged := last != nil && alarmCount == last.AlarmCount &&
+		incoming == last.IncomingPatronsCount && outgoing == last.OutgoingPatronsCount
+	if app.gateStatus.stuckAfter > 0 && last != nil && app.isOpenAt(app.now()) && app.gateStatus.recordCounts(gateName, unchanged) {
+		if !app.alertOnStuck {
+			slog.Warn("Gate counts have stopped changing", "gate", gateName, "cycles", app.gateStatus.stuckAfter)
+		} else {
+			app.alert(Alert{
+				Kind:     "stuck_sensor",
+				GateName: gateName,
+				Message:  "Gate counts haven't changed during open hours; the sensor may be frozen",
+				Detail:   fmt.Sprintf("%d polls with incoming=%d outgoing=%d", app.gateStatus.stuckAfter, incoming, outgoing),
+			})
+		}
+	}
+
+	// Overnight every poll repeats the last reading. Optionally leave those
+	// out of the table; the stored reading still diffs correctly against the
+	// next change, and its polled_through records that the gate was polled so
+	// uptime, gaps and trends don't mistake the quiet for an outage.
+	if app.skipUnchanged && unchanged {
+		slog.Info("Gate count unchanged, skipping insert", "gate", gateName)
+		if _, err := app.db.ExecContext(dbCtx, `
+			UPDATE `+app.table+` SET polled_through = ? WHERE id = ?
+		`, app.now(), last.ID); err != nil {
+			slog.Warn("Failed to record skipped poll", "gate", gateName, "error", err)
+		}
+		return GateCount{GateName: gateName, Building: building}, nil
+	}
+
+	suspect := app.isSuspect(incomingDiff, outgoingDiff)
+	if suspect {
+		slog.Warn("Suspiciously large diff, flagging reading as suspect",
+			"gate", gateName,
+			"threshold", app.suspectThreshold,
+			"incoming", incoming,
+			"incoming_diff", incomingDiff,
+			"outgoing", outgoing,
+			"outgoing_diff", outgoingDiff,
+			"previous_incoming", last.IncomingPatronsCount,
+			"previous_outgoing", last.OutgoingPatronsCount,
+		)
+	}
+
+	// Store new count, optionally aligned to the poll boundary so each cycle
+	// lands on a clean mark regardless of how long the fetch took
+	timestamp := app.now()
+	if app.truncateTimestamps {
+		timestamp = timestamp.Truncate(app.gateInterval(gateName))
+	}
+	gc = GateCount{
+		Timestamp:            timestamp,
+		GateName:             gateName,
+		Building:             building,
+		AlarmCount:           alarmCount,
+		AlarmDiff:            alarmDiff,
+		IncomingPatronsCount: incoming,
+		IncomingDiff:         incomingDiff,
+		OutgoingPatronsCount: outgoing,
+		OutgoingDiff:         outgoingDiff,
+		Suspect:              suspect,
+	}
+	gc.Status = readingStatus(gc)
+	for i, sink := range app.sinks {
+		err := sink.Write(dbCtx, &gc)
+		if errors.Is(err, context.DeadlineExceeded) && i == 0 {
+			return GateCount{}, fmt.Errorf("timed out inserting count after %s: %w", app.pollDBTimeout, err)
+		}
+		if err != nil && i == 0 {
+			return GateCount{}, fmt.Errorf("failed to insert count: %w", err)
+		}
+		if err != nil {
+			slog.Warn("Failed to write reading to sink", "sink", sink.Name(), "gate", gateName, "error", err)
+		}
+	}
+
+	if app.influxMultiplier > 0 && !suspect {
+		app.checkInflux(dbCtx, gc)
 	}
 
 	slog.Info("Gate count updated",
@@ -594,19 +1386,45 @@ func (app *App) updateGateCount(gateURL, gateName string) error {
 		"outgoing", fmt.Sprintf("%d(%+d)", outgoing, outgoingDiff),
 	)
 
-	return nil
+	return gc, nil
 }
 
-func (app *App) getLastCount(gateName string) (*GateCount, error) {
-	var gc GateCount
-	err := app.db.QueryRow(`
-		SELECT timestamp, gate_name, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff 
-		FROM lib_gate_counts 
-		WHERE gate_name = ? 
+// insertFailureMarker records that polling a gate failed, so an outage shows
+// up as explicit rows rather than missing ones. The marker repeats the gate's
+// last counts with zero diffs, which keeps totals and the next reading's diff
+// unchanged. Without a last reading there are no counts to repeat, so no
+// marker is written. It runs on its own deadline since the failure may have
+// been the cycle's running out.
+func (app *App) insertFailureMarker(building, gateName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), app.pollDBTimeout)
+	defer cancel()
+
+	gc := GateCount{Timestamp: app.now(), GateName: gateName, Building: building, Failed: true, Status: statusFailed}
+	if app.truncateTimestamps {
+		gc.Timestamp = gc.Timestamp.Truncate(app.gateInterval(gateName))
+	}
+	last, err := app.getLastCount(ctx, building, gateName)
+	if err != nil {
+		slog.Warn("Failed to get last count, skipping failure marker", "gate", gateName, "error", err)
+		return
+	}
+	if last == nil {
+		return
+	}
+	gc.AlarmCount, gc.IncomingPatronsCount, gc.OutgoingPatronsCount = last.AlarmCount, last.IncomingPatronsCount, last.OutgoingPatronsCount
+	if _, err := app.insertCount(ctx, gc); err != nil {
+		slog.Warn("Failed to insert failure marker", "gate", gateName, "error", err)
+	}
+}
+
+func (app *App) getLastCount(ctx context.Context, building, gateName string) (*GateCount, error) {
+	gc, err := scanGateCount(app.db.QueryRowContext(ctx, `
+		SELECT `+gateCountColumns+`
+		FROM `+app.table+` 
+		WHERE building = ? AND gate_name = ? AND failed = 0
 		ORDER BY timestamp DESC 
 		LIMIT 1
-	`, gateName).Scan(&gc.Timestamp, &gc.GateName, &gc.AlarmCount, &gc.AlarmDiff,
-		&gc.IncomingPatronsCount, &gc.IncomingDiff, &gc.OutgoingPatronsCount, &gc.OutgoingDiff)
+	`, building, gateName))
 
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -618,12 +1436,42 @@ func (app *App) getLastCount(gateName string) (*GateCount, error) {
 	return &gc, nil
 }
 
-func (app *App) insertCount(timestamp time.Time, gateName string, alarmCount, alarmDiff, incoming, incomingDiff, outgoing, outgoingDiff int) error {
-	_, err := app.db.Exec(`
-		INSERT INTO lib_gate_counts (timestamp, gate_name, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff) 
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, timestamp, gateName, alarmCount, alarmDiff, incoming, incomingDiff, outgoing, outgoingDiff)
-	return err
+// isSuspect reports whether an hour's traffic is too large to be real, which
+// almost always means a sensor glitch. A zero threshold disables the check.
+func (app *App) isSuspect(incomingDiff, outgoingDiff int) bool {
+	return app.suspectThreshold > 0 && (incomingDiff > app.suspectThreshold || outgoingDiff > app.suspectThreshold)
+}
+
+// mysqlDeadlock is the error number MariaDB returns when it aborts a
+// statement to break a deadlock.
+const mysqlDeadlock = 1213
+
+// insertCount stores gc and returns its new ID.
+func (app *App) insertCount(ctx context.Context, gc GateCount) (int64, error) {
+	// Concurrent polls can occasionally deadlock on the indexes. The insert is
+	// safe to repeat, so retry a couple of times rather than lose the reading.
+	const attempts = 3
+	for attempt := 1; ; attempt++ {
+		res, err := app.db.ExecContext(ctx, `
+			INSERT INTO `+app.table+` (timestamp, gate_name, building, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff, suspect, failed, status) 
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, gc.Timestamp, gc.GateName, gc.Building, gc.AlarmCount, gc.AlarmDiff, gc.IncomingPatronsCount, gc.IncomingDiff,
+			gc.OutgoingPatronsCount, gc.OutgoingDiff, gc.Suspect, gc.Failed, gc.Status)
+
+		if err == nil {
+			return res.LastInsertId()
+		}
+		var mysqlErr *mysql.MySQLError
+		if !errors.As(err, &mysqlErr) || mysqlErr.Number != mysqlDeadlock || attempt == attempts {
+			return 0, err
+		}
+		slog.Warn("Deadlock inserting count, retrying", "gate", gc.GateName, "attempt", attempt)
+		select {
+		case <-ctx.Done():
+			return 0, err
+		case <-time.After(time.Duration(attempt) * 50 * time.Millisecond):
+		}
+	}
 }
 
 type statusRecorder struct {
@@ -638,7 +1486,7 @@ func (r *statusRecorder) WriteHeader(statusCode int) {
 
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "/health") {
+		if r.URL.Path == healthPath || r.URL.Path == metricsPath || r.URL.Path == pingPath || r.URL.Path == livezPath {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -654,7 +1502,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			"path", r.URL.Path,
 			"status", statusWriter.statusCode,
 			"duration", duration,
-			"client_ip", r.RemoteAddr,
+			"client_ip", clientIP(r),
 			"user_agent", r.UserAgent(),
 		)
 	})
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// maintenanceMiddleware answers every request with a 503 while maintenance
+// mode is on, except the health check, ping, livez, metrics and the toggle
+// used to turn it off.
+func (app *App) maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.maintenance.Load() && r.URL.Path != healthPath && r.URL.Path != metricsPath && r.URL.Path != pingPath && r.URL.Path != livezPath && r.URL.Path != scriptName+"/admin/maintenance" {
+			w.Header().Set("Retry-After", "300")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+				"success":     false,
+				"error":       "service is down for maintenance",
+				"maintenance": true,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMaintenance reports (GET) or sets (POST {"enabled": bool}) maintenance
+// mode.
+func (app *App) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req struct {
+			Enabled *bool `json:"enabled"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil && bodyTooLarge(w, err) {
+			return
+		}
+		if err != nil || req.Enabled == nil {
+			writeError(w, http.StatusBadRequest, `expected {"enabled": true|false}`)
+			return
+		}
+		app.maintenance.Store(*req.Enabled)
+		slog.Info("Maintenance mode changed", "enabled", *req.Enabled, "client_ip", clientIP(r))
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":     true,
+		"maintenance": app.maintenance.Load(),
+	})
+}
+
+// handlePausePolling and handleResumePolling stop and restart recording gate
+// counts, e.g. while sensors are serviced. The worker checks the flag before
+// each cycle.
+func (app *App) handlePausePolling(w http.ResponseWriter, r *http.Request) {
+	app.setPollingPaused(w, r, true)
+}
+
+func (app *App) handleResumePolling(w http.ResponseWriter, r *http.Request) {
+	app.setPollingPaused(w, r, false)
+}
+
+func (app *App) setPollingPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	app.pollingPaused.Store(paused)
+	slog.Info("Polling state changed", "paused", paused, "client_ip", clientIP(r))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"paused":  paused,
+	})
+}
+
+// handlePollNow runs a poll cycle immediately, e.g. to confirm a repaired
+// gate, and reports each gate's outcome. It waits for a scheduled cycle that
+// is already running rather than overlapping it.
+func (app *App) handlePollNow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	slog.Info("On-demand poll requested", "client_ip", clientIP(r))
+	results := app.recordGateCounts(app.gates)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    results,
+	})
+}
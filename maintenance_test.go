@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMaintenanceMiddlewareExemptions(t *testing.T) {
+	app := &App{}
+	app.maintenance.Store(true)
+	handler := app.maintenanceMiddleware(http.HandlerFunc(handlePing))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: pingPath, want: http.StatusOK},
+		{path: livezPath, want: http.StatusOK},
+		{path: metricsPath, want: http.StatusOK},
+		{path: scriptName + "/query", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// handleMetrics exposes per-gate polling state and database pool stats in the
+// Prometheus text format.
+// The few gauges involved don't warrant pulling in the client library.
+func (app *App) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	statuses := app.gateStatus.snapshot()
+	names := make([]string, 0, len(statuses))
+	for name := range statuses {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	gauge := func(name, help string, value func(GateStatus) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, gate := range names {
+			fmt.Fprintf(&b, "%s{gate=%q} %g\n", name, gate, value(statuses[gate]))
+		}
+	}
+	gauge("ole_gate_poll_duration_seconds", "Duration of the most recent fetch, decode and insert for a gate.",
+		func(s GateStatus) float64 { return s.LastPollSeconds })
+	gauge("ole_gate_poll_duration_avg_seconds", "Average poll duration over a gate's recent polls.",
+		func(s GateStatus) float64 { return s.AvgPollSeconds })
+	gauge("ole_gate_consecutive_failures", "Consecutive failed polls for a gate.",
+		func(s GateStatus) float64 { return float64(s.ConsecutiveFailures) })
+	gauge("ole_gate_down", "1 if a gate has failed enough consecutive polls to be considered down.",
+		func(s GateStatus) float64 {
+			if s.Down {
+				return 1
+			}
+			return 0
+		})
+
+	// Connection pool stats show whether dashboard load needs higher limits
+	stats := app.db.Stats()
+	single := func(name, kind, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+	}
+	single("ole_db_max_open_connections", "gauge", "Maximum number of open database connections.", float64(stats.MaxOpenConnections))
+	single("ole_db_open_connections", "gauge", "Open database connections, in use or idle.", float64(stats.OpenConnections))
+	single("ole_db_in_use_connections", "gauge", "Database connections currently in use.", float64(stats.InUse))
+	single("ole_db_idle_connections", "gauge", "Idle database connections.", float64(stats.Idle))
+	single("ole_db_wait_count_total", "counter", "Total connections waited for.", float64(stats.WaitCount))
+	single("ole_db_wait_duration_seconds_total", "counter", "Total time spent waiting for a connection.", stats.WaitDuration.Seconds())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
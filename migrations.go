@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// migrations returns the statements applied in order at startup. Each
+// statement must be idempotent so it is safe to re-run against an already
+// migrated schema.
+func migrations(table string) []string {
+	return []string{
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (
+			id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+			timestamp DATETIME DEFAULT NULL,
+			gate_name VARCHAR(64) CHARACTER SET utf8 COLLATE utf8_general_ci DEFAULT NULL,
+			alarm_count INT(11) DEFAULT NULL,
+			alarm_diff INT(11) DEFAULT NULL,
+			incoming_patrons_count INT(11) DEFAULT NULL,
+			incoming_diff INT(11) DEFAULT NULL,
+			outgoing_patrons_count INT(11) DEFAULT NULL,
+			outgoing_diff INT(11) DEFAULT NULL,
+			KEY ` + table + `_time_idx (timestamp),
+			KEY ` + table + `_name_idx (gate_name),
+			KEY ` + table + `_time_name_idx (timestamp, gate_name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		// Give every reading a stable identifier. MariaDB assigns ids to the
+		// existing rows when the column is added.
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST`,
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS suspect TINYINT(1) NOT NULL DEFAULT 0`,
+		// Failure markers, written in place of a reading when FAILURE_MARKERS
+		// is set and polling a gate fails.
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS failed TINYINT(1) NOT NULL DEFAULT 0`,
+		// Every reading's provenance. Existing rows start as ok and are then
+		// classified from the flags and diffs recorded with them.
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'ok'`,
+		`UPDATE ` + table + `
+			SET status = CASE
+				WHEN failed = 1 THEN 'failed'
+				WHEN suspect = 1 THEN 'suspect'
+				ELSE 'reset'
+			END
+			WHERE status = 'ok' AND (failed = 1 OR suspect = 1 OR alarm_diff < 0 OR incoming_diff < 0 OR outgoing_diff < 0)`,
+		// Existing rows get an empty building, then take it from the
+		// "Building/gate" name prefix they were recorded under, if any.
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS building VARCHAR(64) NOT NULL DEFAULT '' AFTER gate_name`,
+		`UPDATE ` + table + `
+			SET building = SUBSTRING_INDEX(gate_name, '/', 1)
+			WHERE building = '' AND gate_name LIKE '%/%'`,
+		`ALTER TABLE ` + table + `
+			ADD INDEX IF NOT EXISTS ` + table + `_building_time_idx (building, timestamp)`,
+		// The last poll a reading stands for when SKIP_UNCHANGED_READINGS
+		// leaves repeats of it out of the table.
+		`ALTER TABLE ` + table + `
+			ADD COLUMN IF NOT EXISTS polled_through DATETIME DEFAULT NULL`,
+		`CREATE TABLE IF NOT EXISTS ` + companionTable(table, "meta") + ` (
+			gate_name VARCHAR(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL PRIMARY KEY,
+			location VARCHAR(255) NOT NULL DEFAULT '',
+			description TEXT NOT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		`CREATE TABLE IF NOT EXISTS ` + companionTable(table, "totals") + ` (
+			gate_name VARCHAR(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL PRIMARY KEY,
+			entrances BIGINT NOT NULL DEFAULT 0,
+			exits BIGINT NOT NULL DEFAULT 0,
+			alarms BIGINT NOT NULL DEFAULT 0,
+			updated_at DATETIME DEFAULT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+		`CREATE TABLE IF NOT EXISTS ` + companionTable(table, "baseline") + ` (
+			gate_name VARCHAR(64) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL PRIMARY KEY,
+			entrances BIGINT NOT NULL DEFAULT 0,
+			exits BIGINT NOT NULL DEFAULT 0,
+			alarms BIGINT NOT NULL DEFAULT 0,
+			through DATETIME DEFAULT NULL
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci`,
+	}
+}
+
+// defaultCountsTable is the counts table used unless GATE_COUNTS_TABLE says
+// otherwise.
+const defaultCountsTable = "lib_gate_counts"
+
+// companionTable names the per-gate meta, totals or baseline table kept
+// alongside a counts table, so two counts tables in one database don't share
+// them. The default table keeps the original lib_gate_meta style names.
+func companionTable(table, kind string) string {
+	if table == defaultCountsTable {
+		return "lib_gate_" + kind
+	}
+	return table + "_" + kind
+}
+
+func migrate(db *sql.DB, table string) error {
+	for i, stmt := range migrations(table) {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// expectedColumns maps each column the app reads or writes to its MariaDB
+// DATA_TYPE.
+var expectedColumns = map[string]string{
+	"id":                     "bigint",
+	"timestamp":              "datetime",
+	"gate_name":              "varchar",
+	"building":               "varchar",
+	"alarm_count":            "int",
+	"alarm_diff":             "int",
+	"incoming_patrons_count": "int",
+	"incoming_diff":          "int",
+	"outgoing_patrons_count": "int",
+	"outgoing_diff":          "int",
+	"suspect":                "tinyint",
+	"failed":                 "tinyint",
+	"status":                 "varchar",
+	"polled_through":         "datetime",
+}
+
+// validateSchema checks the counts table has the columns the app expects, so
+// a hand-built table with missing or mistyped columns fails at startup rather
+// than on the first insert an hour later.
+func validateSchema(db *sql.DB, table string) error {
+	rows, err := db.Query(`
+		SELECT COLUMN_NAME, DATA_TYPE
+		FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	actual := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return err
+		}
+		actual[strings.ToLower(name)] = strings.ToLower(dataType)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var problems []string
+	for name, want := range expectedColumns {
+		got, ok := actual[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing column %s (%s)", name, want))
+		case got != want:
+			problems = append(problems, fmt.Sprintf("column %s is %s, expected %s", name, got, want))
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return fmt.Errorf("table %s: %s", table, strings.Join(problems, "; "))
+	}
+	return nil
+}
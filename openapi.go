@@ -0,0 +1,407 @@
+package main
+
+import "net/http"
+
+// openAPISpec describes the public query and stats endpoints. It is written
+// by hand, so update it alongside any change to their request or response
+// shapes. serverURL is the absolute base the endpoints are served under, and
+// rootURL the host root the liveness probe sits at outside SCRIPT_NAME.
+func openAPISpec(serverURL, rootURL string) map[string]interface{} {
+	ref := func(name string) map[string]interface{} {
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	jsonContent := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		}
+	}
+	envelope := func(data map[string]interface{}, extra map[string]interface{}) map[string]interface{} {
+		props := map[string]interface{}{
+			"success": map[string]interface{}{"type": "boolean"},
+			"data":    data,
+		}
+		for k, v := range extra {
+			props[k] = v
+		}
+		return map[string]interface{}{"type": "object", "properties": props}
+	}
+	// withRequired marks properties of an object schema as always present
+	withRequired := func(schema map[string]interface{}, fields ...string) map[string]interface{} {
+		schema["required"] = fields
+		return schema
+	}
+	hasData := map[string]interface{}{"type": "boolean", "description": "False when no readings fall in the window, so an empty state can be shown instead of zeros"}
+	arrayOf := func(schema map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"type": "array", "items": schema}
+	}
+	errorResponse := func(description string) map[string]interface{} {
+		return map[string]interface{}{
+			"description": description,
+			"content":     jsonContent(ref("Error")),
+		}
+	}
+	excludeSuspect := map[string]interface{}{
+		"name":        "exclude_suspect",
+		"in":          "query",
+		"description": "Leave readings flagged as suspect out of the totals",
+		"schema":      map[string]interface{}{"type": "boolean"},
+	}
+	building := map[string]interface{}{
+		"name":        "building",
+		"in":          "query",
+		"description": "Only count readings from this configured building. An unknown building is a 400",
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+	status := map[string]interface{}{
+		"name":        "status",
+		"in":          "query",
+		"description": "Comma separated reading statuses to count, e.g. ok,reset. An unknown status is a 400",
+		"schema":      map[string]interface{}{"type": "string"},
+		"example":     "ok,reset",
+	}
+	tz := map[string]interface{}{
+		"name":        "tz",
+		"in":          "query",
+		"description": "IANA time zone to draw day and hour boundaries in. Defaults to the server's TZ",
+		"schema":      map[string]interface{}{"type": "string"},
+		"example":     "America/Chicago",
+	}
+	dateParam := func(name, description string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":        name,
+			"in":          "query",
+			"description": description,
+			"schema":      map[string]interface{}{"type": "string", "format": "date"},
+		}
+	}
+	integer := map[string]interface{}{"type": "integer"}
+	str := map[string]interface{}{"type": "string"}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ole-gate-count",
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": serverURL},
+		},
+		"paths": map[string]interface{}{
+			"/query": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Query gate count readings",
+					"parameters": []interface{}{map[string]interface{}{
+						"name":        "format",
+						"in":          "query",
+						"description": "Response format. Defaults to negotiating on the Accept header",
+						"schema":      map[string]interface{}{"type": "string", "enum": []string{"json", "csv", "jsonl"}},
+					}},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(ref("QueryRequest")),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Matching readings. Send Accept: text/csv for the same CSV as /download_csv",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": envelope(arrayOf(ref("GateCount")), map[string]interface{}{
+										"count":     integer,
+										"truncated": map[string]interface{}{"type": "boolean", "description": "More rows matched than MAX_EXPORT_ROWS; narrow the range to get the rest"},
+									}),
+								},
+								"text/csv": map[string]interface{}{"schema": str},
+								"application/x-ndjson": map[string]interface{}{
+									"schema": ref("GateCount"),
+								},
+							},
+						},
+						"400": errorResponse("Invalid filter"),
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/download_csv": map[string]interface{}{
+				"post": map[string]interface{}{
+					"summary": "Download gate count readings as CSV",
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent(ref("QueryRequest")),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "CSV attachment with the columns timestamp, gate_name, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff",
+							"content": map[string]interface{}{
+								"text/csv": map[string]interface{}{"schema": str},
+							},
+						},
+						"400": map[string]interface{}{"description": "Invalid filter"},
+						"500": map[string]interface{}{"description": "Query failed"},
+					},
+				},
+			},
+			"/monthly_stats": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Entrances and exits per month over the past year",
+					"parameters": []interface{}{excludeSuspect, building, status, map[string]interface{}{
+						"name":        "format",
+						"in":          "query",
+						"description": "csv returns a CSV attachment, as does /monthly_stats.csv. Its columns follow metric, except that entrances gives just month,entrances; ask for entrances,exits to add exits",
+						"schema":      map[string]interface{}{"type": "string", "enum": []string{"json", "csv"}},
+					}, map[string]interface{}{
+						"name":        "metric",
+						"in":          "query",
+						"description": "Which monthly values to report. entrances and entrances,exits also report exits in JSON, both adds total, net adds entrances minus exits",
+						"schema":      map[string]interface{}{"type": "string", "enum": []string{"entrances", "entrances,exits", "exits", "both", "net"}, "default": "entrances"},
+					}},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Monthly totals, oldest first",
+							"content": map[string]interface{}{
+								"application/json": map[string]interface{}{
+									"schema": withRequired(envelope(arrayOf(ref("MonthlyStats")), map[string]interface{}{"has_data": hasData}), "has_data"),
+								},
+								"text/csv": map[string]interface{}{"schema": str},
+							},
+						},
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/recent_stats": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Entrances, exits and alarms over the past three hours",
+					"parameters": []interface{}{excludeSuspect, building, status, map[string]interface{}{
+						"name":        "by_gate",
+						"in":          "query",
+						"description": "Also break the totals out per gate",
+						"schema":      map[string]interface{}{"type": "boolean"},
+					}},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Recent totals",
+							"content":     jsonContent(withRequired(envelope(ref("RecentStats"), map[string]interface{}{"has_data": hasData}), "has_data")),
+						},
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/stats/range": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Total entrances, exits and alarms for the /query filters",
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "gate_name", "in": "query", "schema": str},
+						map[string]interface{}{"name": "fuzzy", "in": "query", "schema": map[string]interface{}{"type": "boolean"}},
+						dateParam("start_date", "First day to include"),
+						dateParam("end_date", "Last day to include"),
+						map[string]interface{}{"name": "bounds", "in": "query", "schema": map[string]interface{}{"type": "string", "enum": []string{"inclusive", "exclusive"}}},
+						excludeSuspect, building, status,
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Totals over the range",
+							"content":     jsonContent(envelope(ref("RangeStats"), nil)),
+						},
+						"400": errorResponse("Invalid filter"),
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/series": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Entrances and exits bucketed by hour, day, week or month",
+					"parameters": []interface{}{
+						map[string]interface{}{"name": "interval", "in": "query", "schema": map[string]interface{}{"type": "string", "enum": []string{"hour", "day", "week", "month"}, "default": "day"}},
+						map[string]interface{}{"name": "interpolate", "in": "query", "description": "Fill runs of up to this many empty buckets between two with readings", "schema": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": maxInterpolate}},
+						dateParam("start_date", "First day to include. Defaults to a week before end_date"),
+						dateParam("end_date", "Last day to include. Defaults to today"),
+						tz, excludeSuspect, building, status,
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "One point per bucket, empty buckets included as zeros",
+							"content": jsonContent(envelope(arrayOf(ref("SeriesPoint")), map[string]interface{}{
+								"interval": str,
+								"tz":       str,
+							})),
+						},
+						"400": errorResponse("Invalid parameter"),
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/occupancy_series": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Running occupancy at each hour of a day",
+					"parameters": []interface{}{
+						dateParam("date", "Day to report. Defaults to today"),
+						tz, excludeSuspect, building, status,
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Hourly occupancy, starting from zero at midnight",
+							"content": jsonContent(envelope(arrayOf(ref("OccupancyPoint")), map[string]interface{}{
+								"date": map[string]interface{}{"type": "string", "format": "date"},
+								"tz":   str,
+							})),
+						},
+						"400": errorResponse("Invalid parameter"),
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			"/capacity_series": map[string]interface{}{
+				"get": map[string]interface{}{
+					"summary": "Hourly occupancy of a day as a percentage of BUILDING_CAPACITY",
+					"parameters": []interface{}{
+						dateParam("date", "Day to report. Defaults to today"),
+						tz, excludeSuspect, building, status,
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "Hourly occupancy against capacity",
+							"content": jsonContent(envelope(arrayOf(ref("CapacityPoint")), map[string]interface{}{
+								"date":     map[string]interface{}{"type": "string", "format": "date"},
+								"tz":       str,
+								"capacity": integer,
+							})),
+						},
+						"400": errorResponse("Invalid parameter"),
+						"404": errorResponse("No building capacity is configured"),
+						"500": errorResponse("Query failed"),
+					},
+				},
+			},
+			livezPath: map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"url": rootURL},
+				},
+				"get": map[string]interface{}{
+					"summary": "Liveness probe that never touches the database and keeps answering in maintenance mode",
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "The server is up",
+							"content": map[string]interface{}{
+								"text/plain": map[string]interface{}{"schema": str, "example": "ok"},
+							},
+						},
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"QueryRequest": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"gate_name":   map[string]interface{}{"type": "string", "description": "Exact gate name to match. Omit or leave empty for all gates"},
+						"fuzzy":       map[string]interface{}{"type": "boolean", "description": "Match gate_name as a substring instead of exactly"},
+						"building":    map[string]interface{}{"type": "string", "description": "Only gates of this configured building"},
+						"start_date":  map[string]interface{}{"type": "string", "format": "date"},
+						"end_date":    map[string]interface{}{"type": "string", "format": "date"},
+						"order_by":    map[string]interface{}{"type": "string", "enum": []string{"asc", "desc"}},
+						"allow_large": map[string]interface{}{"type": "boolean", "description": "Allow ranges longer than the configured maximum"},
+						"bounds":      map[string]interface{}{"type": "string", "enum": []string{"inclusive", "exclusive"}, "description": "inclusive (default) includes all of end_date; exclusive stops at its start"},
+					},
+				},
+				"GateCount": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"id":                     integer,
+						"timestamp":              map[string]interface{}{"type": "string", "format": "date-time"},
+						"gate_name":              str,
+						"building":               str,
+						"alarm_count":            integer,
+						"alarm_diff":             integer,
+						"incoming_patrons_count": integer,
+						"incoming_diff":          integer,
+						"outgoing_patrons_count": integer,
+						"outgoing_diff":          integer,
+						"suspect":                map[string]interface{}{"type": "boolean"},
+						"failed":                 map[string]interface{}{"type": "boolean"},
+						"status":                 map[string]interface{}{"type": "string", "enum": readingStatuses},
+					},
+				},
+				"MonthlyStats": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"month":     map[string]interface{}{"type": "string", "example": "2025-09"},
+						"entrances": integer,
+						"exits":     integer,
+						"total":     integer,
+						"net":       integer,
+					},
+				},
+				"RecentStats": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"total_entrances": integer,
+						"total_exits":     integer,
+						"total_alarms":    integer,
+						"gates": arrayOf(map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"gate_name":       str,
+								"total_entrances": integer,
+								"total_exits":     integer,
+								"total_alarms":    integer,
+							},
+						}),
+					},
+				},
+				"RangeStats": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"total_entrances": integer,
+						"total_exits":     integer,
+						"total_alarms":    integer,
+					},
+				},
+				"SeriesPoint": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"start":        map[string]interface{}{"type": "string", "format": "date-time"},
+						"entrances":    integer,
+						"exits":        integer,
+						"interpolated": map[string]interface{}{"type": "boolean"},
+						"failed_polls": integer,
+					},
+				},
+				"OccupancyPoint": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"timestamp": map[string]interface{}{"type": "string", "format": "date-time"},
+						"entrances": integer,
+						"exits":     integer,
+						"occupancy": integer,
+					},
+				},
+				"CapacityPoint": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"timestamp":     map[string]interface{}{"type": "string", "format": "date-time"},
+						"occupancy":     integer,
+						"percent":       map[string]interface{}{"type": "number"},
+						"over_capacity": map[string]interface{}{"type": "boolean"},
+					},
+				},
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"success": map[string]interface{}{"type": "boolean"},
+						"error":   str,
+					},
+				},
+			},
+		},
+	}
+}
+
+func (app *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The document describes the snake_case names, so it is served as written
+	writeJSON(withoutCamelCase(w), http.StatusOK, openAPISpec(externalURL(r, scriptName), externalURL(r, "")))
+}
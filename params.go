@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// rangeParams reads the start_date and end_date query parameters of a GET
+// endpoint as a half-open [start, end) interval of whole days in loc. The end
+// date defaults to today and the start date to defaultDays before it. Ranges
+// are subject to the same maximum as /query unless allow_large=true is set.
+func (app *App) rangeParams(r *http.Request, defaultDays int, loc *time.Location) (time.Time, time.Time, error) {
+	q := r.URL.Query()
+	today := app.now().In(loc)
+	endDate := q.Get("end_date")
+	if endDate == "" {
+		endDate = today.Format(dateLayout)
+	}
+	startDate := q.Get("start_date")
+	if startDate == "" {
+		if end, err := time.ParseInLocation(dateLayout, endDate, loc); err == nil {
+			startDate = end.AddDate(0, 0, -defaultDays).Format(dateLayout)
+		}
+	}
+
+	allowLarge, _ := strconv.ParseBool(q.Get("allow_large"))
+	if err := app.checkDateRange(startDate, endDate, allowLarge); err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	start, _ := time.ParseInLocation(dateLayout, startDate, loc)
+	end, _ := time.ParseInLocation(dateLayout, endDate, loc)
+	end = end.AddDate(0, 0, 1)
+	if !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end_date must not be before start_date")
+	}
+	return start, end, nil
+}
+
+// dayParam reads the date query parameter of a single-day endpoint as a day
+// in loc, defaulting to today.
+func (app *App) dayParam(r *http.Request, loc *time.Location) (time.Time, error) {
+	v := r.URL.Query().Get("date")
+	if v == "" {
+		return app.now().In(loc), nil
+	}
+	day, err := time.ParseInLocation(dateLayout, v, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date: expected YYYY-MM-DD")
+	}
+	return day, nil
+}
+
+// suspectFilter returns the extra condition that drops readings flagged as
+// suspect when a stats request sets exclude_suspect=true.
+func suspectFilter(r *http.Request) string {
+	if exclude, _ := strconv.ParseBool(r.URL.Query().Get("exclude_suspect")); exclude {
+		return " AND suspect = 0"
+	}
+	return ""
+}
+
+// statsFilter combines the optional filters every stats endpoint accepts into
+// conditions to add to a WHERE clause and the arguments they bind, which go
+// after the query's own.
+func statsFilter(r *http.Request) (string, []any) {
+	building, args := buildingFilter(r)
+	status, statusArgs := statusFilter(r)
+	return suspectFilter(r) + building + status, append(args, statusArgs...)
+}
+
+// statusFilter limits a stats query to readings whose status is in the
+// comma separated status query parameter, e.g. status=ok,reset, returning the
+// condition and its arguments.
+func statusFilter(r *http.Request) (string, []any) {
+	v := r.URL.Query().Get("status")
+	if v == "" {
+		return "", nil
+	}
+	statuses := strings.Split(v, ",")
+	args := make([]any, len(statuses))
+	for i, s := range statuses {
+		args[i] = s
+	}
+	return " AND status IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(statuses)), ", ") + ")", args
+}
+
+// filterParamsMiddleware rejects building and status query parameters that
+// name no configured building or known status, so a typo doesn't quietly
+// report zeros.
+func (app *App) filterParamsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if b := q.Get("building"); b != "" && !app.hasBuilding(b) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown building %q", b))
+			return
+		}
+		if v := q.Get("status"); v != "" {
+			for _, s := range strings.Split(v, ",") {
+				if !slices.Contains(readingStatuses, s) {
+					writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown status %q: must be one of %s", s, strings.Join(readingStatuses, ", ")))
+					return
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tzParam returns the IANA time zone named by the tz query parameter, used to
+// draw day and hour boundaries for a consumer in another zone. It defaults to
+// the app's own zone.
+func tzParam(r *http.Request) (*time.Location, error) {
+	name := r.URL.Query().Get("tz")
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tz %q: expected an IANA time zone such as America/Chicago", name)
+	}
+	return loc, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsFilter(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantSQL  string
+		wantArgs []any
+	}{
+		{query: "", wantSQL: ""},
+		{query: "exclude_suspect=true", wantSQL: " AND suspect = 0"},
+		{query: "building=Linderman", wantSQL: " AND building = ?", wantArgs: []any{"Linderman"}},
+		{query: "status=ok", wantSQL: " AND status IN (?)", wantArgs: []any{"ok"}},
+		{query: "status=ok,reset", wantSQL: " AND status IN (?, ?)", wantArgs: []any{"ok", "reset"}},
+		{
+			query:    "exclude_suspect=1&building=Fairchild&status=manual,imported",
+			wantSQL:  " AND suspect = 0 AND building = ? AND status IN (?, ?)",
+			wantArgs: []any{"Fairchild", "manual", "imported"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/stats?"+tt.query, nil)
+			sql, args := statsFilter(r)
+			if sql != tt.wantSQL {
+				t.Errorf("statsFilter SQL = %q, want %q", sql, tt.wantSQL)
+			}
+			if len(args) != len(tt.wantArgs) || (len(args) > 0 && !reflect.DeepEqual(args, tt.wantArgs)) {
+				t.Errorf("statsFilter args = %v, want %v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestRangeStatsAppliesStatsFilters(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantSQL  []string
+		wantArgs []driver.Value
+	}{
+		{
+			query:    "status=ok,manual",
+			wantSQL:  []string{"status IN (?, ?)"},
+			wantArgs: []driver.Value{"ok", "manual"},
+		},
+		{
+			query:    "building=Linderman&exclude_suspect=true",
+			wantSQL:  []string{"building = ?", "suspect = 0"},
+			wantArgs: []driver.Value{"Linderman"},
+		},
+		{
+			query:    "gate_name=Gate+1&status=reset",
+			wantSQL:  []string{"gate_name = ?", "status IN (?)"},
+			wantArgs: []driver.Value{"Gate 1", "reset"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			var sums struct {
+				query string
+				args  []driver.Value
+			}
+			d := &fakeDB{query: func(query string, args []driver.NamedValue) (fakeResult, error) {
+				if strings.Contains(query, "SUM(") {
+					sums.query = query
+					for _, a := range args {
+						sums.args = append(sums.args, a.Value)
+					}
+					return fakeResult{columns: []string{"in", "out", "alarms"}, rows: [][]driver.Value{{int64(1), int64(2), int64(3)}}}, nil
+				}
+				return fakeResult{columns: []string{"latest"}, rows: [][]driver.Value{{nil}}}, nil
+			}}
+			app := &App{db: d.open(t), table: "lib_gate_counts", now: time.Now, queryTimeout: time.Minute,
+				buildings: []string{"Linderman"}}
+
+			rr := httptest.NewRecorder()
+			app.handleRangeStats(rr, httptest.NewRequest(http.MethodGet, "/stats/range?"+tt.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+			}
+			for _, s := range tt.wantSQL {
+				if strings.Count(sums.query, s) != 1 {
+					t.Errorf("query %q should contain %q once", sums.query, s)
+				}
+			}
+			if !reflect.DeepEqual(sums.args, tt.wantArgs) {
+				t.Errorf("args = %v, want %v", sums.args, tt.wantArgs)
+			}
+		})
+	}
+}
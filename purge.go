@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// retentionWorker deletes readings older than RETENTION_DAYS once at startup
+// and then daily.
+func (app *App) retentionWorker() {
+	if app.retentionDays <= 0 {
+		return
+	}
+
+	slog.Info("Starting retention worker", "retention_days", app.retentionDays)
+	for {
+		if err := app.purgeOldReadings(); err != nil {
+			slog.Error("Failed to purge old readings", "error", err)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// purgeOldReadings deletes whole days of readings past the retention period.
+// Before deleting, their totals are added to each gate's carry-forward
+// baseline table within the same transaction, so all-time
+// totals and net occupancy come out the same after the purge as before it.
+// The cutoff is a local midnight so no day is left partly purged and daily
+// occupancy, which starts from zero each midnight, is unaffected for the days
+// that remain.
+func (app *App) purgeOldReadings() error {
+	now := app.now()
+	cutoff := time.Date(now.Year(), now.Month(), now.Day()-app.retentionDays, 0, 0, 0, 0, time.Local)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO `+companionTable(app.table, "baseline")+` (gate_name, entrances, exits, alarms, through)
+		SELECT gate_name,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0),
+			MAX(timestamp)
+		FROM `+app.table+`
+		WHERE timestamp < ? AND gate_name IS NOT NULL
+		GROUP BY gate_name
+		ON DUPLICATE KEY UPDATE
+			entrances = entrances + VALUES(entrances),
+			exits = exits + VALUES(exits),
+			alarms = alarms + VALUES(alarms),
+			through = GREATEST(COALESCE(through, VALUES(through)), VALUES(through))
+	`, cutoff); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM "+app.table+" WHERE timestamp < ?", cutoff)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	app.markEdited()
+
+	deleted, _ := res.RowsAffected()
+	slog.Info("Purged old readings", "before", cutoff.Format(dateLayout), "rows", deleted)
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RawCount struct {
+	Timestamp            time.Time `json:"timestamp"`
+	AlarmCount           int       `json:"alarm_count"`
+	IncomingPatronsCount int       `json:"incoming_patrons_count"`
+	OutgoingPatronsCount int       `json:"outgoing_patrons_count"`
+	Reset                bool      `json:"reset"`
+}
+
+// handleRawCounts returns a gate's cumulative counter values over
+// start_date/end_date (default the past week) for diagnosing sensor resets
+// and rollovers. A reading is marked reset when any counter dropped since the
+// previous one.
+func (app *App) handleRawCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gateName := r.URL.Query().Get("gate_name")
+	if gateName == "" {
+		writeError(w, http.StatusBadRequest, "gate_name is required")
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 7, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, COALESCE(alarm_count, 0), COALESCE(incoming_patrons_count, 0), COALESCE(outgoing_patrons_count, 0)
+		FROM `+app.table+`
+		WHERE gate_name = ? AND timestamp >= ? AND timestamp < ? AND failed = 0
+		ORDER BY timestamp, id
+	`, gateName, start, end)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	counts := []RawCount{}
+	for rows.Next() {
+		var c RawCount
+		if err := rows.Scan(&c.Timestamp, &c.AlarmCount, &c.IncomingPatronsCount, &c.OutgoingPatronsCount); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Timestamp = c.Timestamp.In(time.Local)
+		if n := len(counts); n > 0 {
+			prev := counts[n-1]
+			c.Reset = c.AlarmCount < prev.AlarmCount ||
+				c.IncomingPatronsCount < prev.IncomingPatronsCount ||
+				c.OutgoingPatronsCount < prev.OutgoingPatronsCount
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"gate_name": gateName,
+		"data":      counts,
+	})
+}
+
+// maxRecentReadings caps ?n= on /recent_readings.
+const maxRecentReadings = 500
+
+// handleRecentReadings returns each gate's last n readings (?n=, default 24),
+// newest first, or just one gate's with ?gate_name=. A run of identical raw
+// counts is the quickest sign of a frozen sensor.
+func (app *App) handleRecentReadings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := 24
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 || n > maxRecentReadings {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxRecentReadings))
+			return
+		}
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	var gateNames []string
+	if name := r.URL.Query().Get("gate_name"); name != "" {
+		gateNames = []string{name}
+	} else {
+		gates, err := app.listGates(ctx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, g := range gates {
+			gateNames = append(gateNames, g.Name)
+		}
+	}
+
+	readings := map[string][]GateCount{}
+	for _, gateName := range gateNames {
+		counts, err := app.getLastCounts(ctx, gateName, n)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		readings[gateName] = counts
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"n":       n,
+		"data":    readings,
+	})
+}
+
+// getLastCounts is getLastCount for the latest n readings of a gate.
+func (app *App) getLastCounts(ctx context.Context, gateName string, n int) ([]GateCount, error) {
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT `+gateCountColumns+`
+		FROM `+app.table+`
+		WHERE gate_name = ?
+		ORDER BY timestamp DESC, id DESC
+		LIMIT ?
+	`, gateName, n)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []GateCount{}
+	for rows.Next() {
+		gc, err := scanGateCount(rows)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, gc)
+	}
+	return counts, rows.Err()
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const gateCountColumns = "id, timestamp, gate_name, building, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff, suspect, failed, status"
+
+// Reading statuses. The poller records ok, reset (a counter went backwards),
+// suspect or failed; readings entered by an admin are manual, and imported
+// marks rows loaded from another system.
+const (
+	statusOK       = "ok"
+	statusReset    = "reset"
+	statusSuspect  = "suspect"
+	statusFailed   = "failed"
+	statusManual   = "manual"
+	statusImported = "imported"
+)
+
+var readingStatuses = []string{statusOK, statusReset, statusSuspect, statusFailed, statusManual, statusImported}
+
+// readingStatus classifies a polled reading from its flags and diffs.
+func readingStatus(gc GateCount) string {
+	switch {
+	case gc.Failed:
+		return statusFailed
+	case gc.Suspect:
+		return statusSuspect
+	case gc.AlarmDiff < 0 || gc.IncomingDiff < 0 || gc.OutgoingDiff < 0:
+		return statusReset
+	default:
+		return statusOK
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanGateCount(s rowScanner) (GateCount, error) {
+	var gc GateCount
+	err := s.Scan(&gc.ID, &gc.Timestamp, &gc.GateName, &gc.Building, &gc.AlarmCount, &gc.AlarmDiff,
+		&gc.IncomingPatronsCount, &gc.IncomingDiff, &gc.OutgoingPatronsCount, &gc.OutgoingDiff, &gc.Suspect, &gc.Failed, &gc.Status)
+	gc.Timestamp = gc.Timestamp.In(time.Local)
+	return gc, err
+}
+
+// handleRecord lets an admin correct (PATCH) or remove (DELETE) a single
+// reading by id.
+func (app *App) handleRecord(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeError(w, http.StatusBadRequest, "invalid record id")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		app.patchRecord(w, r, id)
+	case http.MethodDelete:
+		app.deleteRecord(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (app *App) patchRecord(w http.ResponseWriter, r *http.Request, id int64) {
+	var req struct {
+		AlarmCount           *int `json:"alarm_count"`
+		IncomingPatronsCount *int `json:"incoming_patrons_count"`
+		OutgoingPatronsCount *int `json:"outgoing_patrons_count"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.AlarmCount == nil && req.IncomingPatronsCount == nil && req.OutgoingPatronsCount == nil {
+		writeError(w, http.StatusBadRequest, "no counts to update")
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	before, err := app.getRecord(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	after := before
+	if req.AlarmCount != nil {
+		after.AlarmCount = *req.AlarmCount
+	}
+	if req.IncomingPatronsCount != nil {
+		after.IncomingPatronsCount = *req.IncomingPatronsCount
+	}
+	if req.OutgoingPatronsCount != nil {
+		after.OutgoingPatronsCount = *req.OutgoingPatronsCount
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE `+app.table+`
+		SET alarm_count = ?, incoming_patrons_count = ?, outgoing_patrons_count = ?, status = ?
+		WHERE id = ?
+	`, after.AlarmCount, after.IncomingPatronsCount, after.OutgoingPatronsCount, statusManual, id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := app.recomputeDiffs(ctx, tx, after.GateName, after.Timestamp); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if after, err = app.getRecord(ctx, tx, id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	app.markEdited()
+
+	slog.Info("Record corrected",
+		"id", id,
+		"gate", after.GateName,
+		"timestamp", after.Timestamp,
+		"before", fmt.Sprintf("%d/%d/%d", before.AlarmCount, before.IncomingPatronsCount, before.OutgoingPatronsCount),
+		"after", fmt.Sprintf("%d/%d/%d", after.AlarmCount, after.IncomingPatronsCount, after.OutgoingPatronsCount),
+		"client_ip", clientIP(r),
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    after,
+	})
+}
+
+func (app *App) deleteRecord(w http.ResponseWriter, r *http.Request, id int64) {
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	record, err := app.getRecord(ctx, tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, "record not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+app.table+" WHERE id = ?", id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// The following reading now diffs against the one before the deleted row.
+	if err := app.recomputeDiffs(ctx, tx, record.GateName, record.Timestamp); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	app.markEdited()
+
+	slog.Info("Record deleted",
+		"id", id,
+		"gate", record.GateName,
+		"timestamp", record.Timestamp,
+		"counts", fmt.Sprintf("%d/%d/%d", record.AlarmCount, record.IncomingPatronsCount, record.OutgoingPatronsCount),
+		"client_ip", clientIP(r),
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    record,
+	})
+}
+
+// handleBackfill inserts a reading recovered after the fact at an arbitrary
+// past timestamp. Unlike updateGateCount, which always diffs against the latest
+// row, the new reading's diffs come from the row chronologically before it and
+// the row after it is re-diffed against the new reading.
+func (app *App) handleBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		GateName             string `json:"gate_name"`
+		Timestamp            string `json:"timestamp"`
+		AlarmCount           int    `json:"alarm_count"`
+		IncomingPatronsCount int    `json:"incoming_patrons_count"`
+		OutgoingPatronsCount int    `json:"outgoing_patrons_count"`
+		Imported             bool   `json:"imported"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		if bodyTooLarge(w, err) {
+			return
+		}
+		writeError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	if req.GateName == "" {
+		writeError(w, http.StatusBadRequest, "gate_name is required")
+		return
+	}
+	ts, err := parseTimestamp(req.Timestamp)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ts.After(app.now()) {
+		writeError(w, http.StatusBadRequest, "timestamp must be in the past")
+		return
+	}
+
+	// Readings loaded in bulk from another system are told apart from ones
+	// an admin recovered by hand
+	status := statusManual
+	if req.Imported {
+		status = statusImported
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO `+app.table+` (timestamp, gate_name, building, alarm_count, alarm_diff, incoming_patrons_count, incoming_diff, outgoing_patrons_count, outgoing_diff, status)
+		VALUES (?, ?, ?, ?, 0, ?, 0, ?, 0, ?)
+	`, ts, req.GateName, app.buildingOf(req.GateName), req.AlarmCount, req.IncomingPatronsCount, req.OutgoingPatronsCount, status)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := app.recomputeDiffs(ctx, tx, req.GateName, ts); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	record, err := app.getRecord(ctx, tx, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	app.markEdited()
+
+	slog.Info("Record backfilled",
+		"id", id,
+		"gate", record.GateName,
+		"timestamp", record.Timestamp,
+		"counts", fmt.Sprintf("%d/%d/%d", record.AlarmCount, record.IncomingPatronsCount, record.OutgoingPatronsCount),
+		"client_ip", clientIP(r),
+	)
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"success": true,
+		"data":    record,
+	})
+}
+
+// parseTimestamp accepts RFC 3339 or a local "2006-01-02 15:04:05" timestamp.
+func parseTimestamp(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.In(time.Local), nil
+	}
+	if t, err := time.ParseInLocation(time.DateTime, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q: expected RFC 3339 or YYYY-MM-DD HH:MM:SS", s)
+}
+
+func (app *App) getRecord(ctx context.Context, tx *sql.Tx, id int64) (GateCount, error) {
+	return scanGateCount(tx.QueryRowContext(ctx, "SELECT "+gateCountColumns+" FROM "+app.table+" WHERE id = ?", id))
+}
+
+// recomputeRowDiffs recalculates a row's diffs against the reading that
+// precedes it for the same gate, reclassifying its status unless it is manual
+// or imported, and returns the updated row.
+func (app *App) recomputeRowDiffs(ctx context.Context, tx *sql.Tx, id int64) (GateCount, error) {
+	gc, err := app.getRecord(ctx, tx, id)
+	if err != nil {
+		return gc, err
+	}
+
+	prev, err := scanGateCount(tx.QueryRowContext(ctx, `
+		SELECT `+gateCountColumns+`
+		FROM `+app.table+`
+		WHERE gate_name = ? AND failed = 0 AND (timestamp < ? OR (timestamp = ? AND id < ?))
+		ORDER BY timestamp DESC, id DESC
+		LIMIT 1
+	`, gc.GateName, gc.Timestamp, gc.Timestamp, gc.ID))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		gc.AlarmDiff, gc.IncomingDiff, gc.OutgoingDiff = 0, 0, 0
+	case err != nil:
+		return gc, err
+	default:
+		gc.AlarmDiff = gc.AlarmCount - prev.AlarmCount
+		gc.IncomingDiff = gc.IncomingPatronsCount - prev.IncomingPatronsCount
+		gc.OutgoingDiff = gc.OutgoingPatronsCount - prev.OutgoingPatronsCount
+	}
+
+	gc.Suspect = app.isSuspect(gc.IncomingDiff, gc.OutgoingDiff)
+	if gc.Status != statusManual && gc.Status != statusImported {
+		gc.Status = readingStatus(gc)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE `+app.table+`
+		SET alarm_diff = ?, incoming_diff = ?, outgoing_diff = ?, suspect = ?, status = ?
+		WHERE id = ?
+	`, gc.AlarmDiff, gc.IncomingDiff, gc.OutgoingDiff, gc.Suspect, gc.Status, gc.ID)
+	return gc, err
+}
+
+// recomputeDiffs recalculates the diffs of the gate's readings at fromTimestamp
+// and of the first reading after it. Diffs are derived from adjacent rows, so
+// these are the only readings affected by editing or removing the row at
+// fromTimestamp. Failure markers are never diffed against, so they're passed
+// over to reach the next real reading. The gate's running total is
+// recomputed to match.
+func (app *App) recomputeDiffs(ctx context.Context, tx *sql.Tx, gateName string, fromTimestamp time.Time) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, timestamp FROM `+app.table+`
+		WHERE gate_name = ? AND timestamp >= ? AND failed = 0
+		ORDER BY timestamp ASC, id ASC
+	`, gateName, fromTimestamp)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var ts time.Time
+		if err := rows.Scan(&id, &ts); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		if ts.After(fromTimestamp) {
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if _, err := app.recomputeRowDiffs(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return app.resetRunningTotal(ctx, tx, gateName)
+}
+
+// handleRederiveDiffs recomputes every stored diff from the raw counts, e.g.
+// after a bulk import or a series of corrections. Each gate (or just
+// ?gate_name=) is walked in timestamp order inside its own transaction, using
+// the same rules as the poller: the first reading has zero diffs and a
+// counter reset yields a negative diff, which the stats ignore. Only rows
+// whose diffs, suspect flag or status change are written; manual and
+// imported readings keep their status.
+func (app *App) handleRederiveDiffs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// This walks the whole table, so allow far longer than a normal query
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Minute)
+	defer cancel()
+
+	var gateNames []string
+	if name := r.URL.Query().Get("gate_name"); name != "" {
+		gateNames = []string{name}
+	} else {
+		gates, err := app.listGates(ctx)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, g := range gates {
+			gateNames = append(gateNames, g.Name)
+		}
+	}
+
+	changed := map[string]int{}
+	total := 0
+	for _, gateName := range gateNames {
+		n, err := app.rederiveGateDiffs(ctx, gateName)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, fmt.Sprintf("gate %q: %v", gateName, err))
+			return
+		}
+		changed[gateName] = n
+		total += n
+	}
+
+	slog.Info("Diffs re-derived", "gates", len(gateNames), "rows_changed", total, "client_ip", clientIP(r))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":      true,
+		"rows_changed": total,
+		"data":         changed,
+	})
+}
+
+func (app *App) rederiveGateDiffs(ctx context.Context, gateName string) (int, error) {
+	tx, err := app.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	n, err := app.rederiveGateDiffsTx(ctx, tx, gateName)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	app.markEdited()
+	return n, nil
+}
+
+// rederiveGateDiffsTx rewrites a gate's diffs, suspect flags and statuses
+// within tx and returns how many rows changed.
+func (app *App) rederiveGateDiffsTx(ctx context.Context, tx *sql.Tx, gateName string) (int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+gateCountColumns+`
+		FROM `+app.table+`
+		WHERE gate_name = ?
+		ORDER BY timestamp ASC, id ASC
+	`, gateName)
+	if err != nil {
+		return 0, err
+	}
+
+	var updates []GateCount
+	var prev *GateCount
+	for rows.Next() {
+		gc, err := scanGateCount(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		want := gc
+		want.AlarmDiff, want.IncomingDiff, want.OutgoingDiff = 0, 0, 0
+		if prev != nil {
+			want.AlarmDiff = gc.AlarmCount - prev.AlarmCount
+			want.IncomingDiff = gc.IncomingPatronsCount - prev.IncomingPatronsCount
+			want.OutgoingDiff = gc.OutgoingPatronsCount - prev.OutgoingPatronsCount
+		}
+		want.Suspect = app.isSuspect(want.IncomingDiff, want.OutgoingDiff)
+		if gc.Status != statusManual && gc.Status != statusImported {
+			want.Status = readingStatus(want)
+		}
+		if want != gc {
+			updates = append(updates, want)
+		}
+		// Failure markers aren't readings, so the next row diffs past them
+		if !gc.Failed {
+			prev = &gc
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, gc := range updates {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE `+app.table+`
+			SET alarm_diff = ?, incoming_diff = ?, outgoing_diff = ?, suspect = ?, status = ?
+			WHERE id = ?
+		`, gc.AlarmDiff, gc.IncomingDiff, gc.OutgoingDiff, gc.Suspect, gc.Status, gc.ID); err != nil {
+			return 0, err
+		}
+	}
+	if len(updates) > 0 {
+		if err := app.resetRunningTotal(ctx, tx, gateName); err != nil {
+			return 0, err
+		}
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeCountsTable serves the handful of queries recomputeDiffs runs from an
+// in-memory table, honouring the failed filter only where a query asks for it.
+type fakeCountsTable struct {
+	rows []GateCount
+}
+
+func (f *fakeCountsTable) values(gc GateCount) []driver.Value {
+	return []driver.Value{gc.ID, gc.Timestamp, gc.GateName, gc.Building,
+		int64(gc.AlarmCount), int64(gc.AlarmDiff), int64(gc.IncomingPatronsCount), int64(gc.IncomingDiff),
+		int64(gc.OutgoingPatronsCount), int64(gc.OutgoingDiff), gc.Suspect, gc.Failed, gc.Status}
+}
+
+func (f *fakeCountsTable) sorted(keep func(GateCount) bool) []GateCount {
+	var out []GateCount
+	for _, gc := range f.rows {
+		if keep(gc) {
+			out = append(out, gc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Timestamp.Equal(out[j].Timestamp) {
+			return out[i].Timestamp.Before(out[j].Timestamp)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+func (f *fakeCountsTable) query(query string, args []driver.NamedValue) (fakeResult, error) {
+	skipFailed := strings.Contains(query, "failed = 0")
+	res := fakeResult{columns: strings.Split(gateCountColumns, ", ")}
+	switch {
+	case strings.Contains(query, "SELECT id, timestamp FROM"):
+		gate, from := args[0].Value.(string), args[1].Value.(time.Time)
+		res.columns = []string{"id", "timestamp"}
+		for _, gc := range f.sorted(func(gc GateCount) bool {
+			return gc.GateName == gate && !gc.Timestamp.Before(from) && !(skipFailed && gc.Failed)
+		}) {
+			res.rows = append(res.rows, []driver.Value{gc.ID, gc.Timestamp})
+		}
+	case strings.Contains(query, "ORDER BY timestamp DESC, id DESC"):
+		gate, ts, id := args[0].Value.(string), args[1].Value.(time.Time), args[3].Value.(int64)
+		prev := f.sorted(func(gc GateCount) bool {
+			before := gc.Timestamp.Before(ts) || (gc.Timestamp.Equal(ts) && gc.ID < id)
+			return gc.GateName == gate && before && !(skipFailed && gc.Failed)
+		})
+		if len(prev) > 0 {
+			res.rows = append(res.rows, f.values(prev[len(prev)-1]))
+		}
+	case strings.Contains(query, "WHERE id = ?"):
+		for _, gc := range f.rows {
+			if gc.ID == args[0].Value.(int64) {
+				res.rows = append(res.rows, f.values(gc))
+			}
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeCountsTable) exec(query string, args []driver.NamedValue) {
+	if !strings.Contains(query, "SET alarm_diff = ?") {
+		return
+	}
+	for i := range f.rows {
+		if f.rows[i].ID == args[5].Value.(int64) {
+			f.rows[i].AlarmDiff = int(args[0].Value.(int64))
+			f.rows[i].IncomingDiff = int(args[1].Value.(int64))
+			f.rows[i].OutgoingDiff = int(args[2].Value.(int64))
+		}
+	}
+}
+
+func TestRecomputeDiffsAroundFailureMarkers(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 3, 2, h, 0, 0, 0, time.UTC) }
+	reading := func(id int64, h, incoming, diff int) GateCount {
+		return GateCount{ID: id, Timestamp: at(h), GateName: "Gate 1", IncomingPatronsCount: incoming, IncomingDiff: diff, Status: statusOK}
+	}
+	marker := func(id int64, h, incoming int) GateCount {
+		gc := reading(id, h, incoming, 0)
+		gc.Failed, gc.Status = true, statusFailed
+		return gc
+	}
+
+	tests := []struct {
+		name string
+		rows []GateCount
+		from time.Time
+		// want is each real reading's incoming diff afterwards, by id
+		want map[int64]int
+	}{
+		{
+			name: "edited reading followed by a marker",
+			// Reading 1 was corrected from 100 to 120
+			rows: []GateCount{reading(1, 9, 120, 0), marker(2, 10, 100), reading(3, 11, 150, 50)},
+			from: at(9),
+			want: map[int64]int{1: 0, 3: 30},
+		},
+		{
+			name: "deleted reading followed by a marker",
+			// The reading at 10:00 with 100 entrances was deleted
+			rows: []GateCount{reading(1, 9, 90, 0), marker(2, 11, 100), reading(3, 12, 150, 50)},
+			from: at(10),
+			want: map[int64]int{1: 0, 3: 60},
+		},
+		{
+			name: "markers on both sides",
+			rows: []GateCount{reading(1, 8, 80, 0), marker(2, 9, 80), reading(4, 10, 110, 0), marker(5, 11, 110), reading(6, 12, 140, 20)},
+			from: at(10),
+			want: map[int64]int{1: 0, 4: 30, 6: 30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &fakeCountsTable{rows: tt.rows}
+			d := &fakeDB{query: table.query, exec: table.exec}
+			app := &App{db: d.open(t), table: "lib_gate_counts"}
+
+			ctx := context.Background()
+			tx, err := app.db.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := app.recomputeDiffs(ctx, tx, "Gate 1", tt.from); err != nil {
+				t.Fatalf("recomputeDiffs: %v", err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, gc := range table.rows {
+				if want, ok := tt.want[gc.ID]; ok && gc.IncomingDiff != want {
+					t.Errorf("reading %d incoming_diff = %d, want %d", gc.ID, gc.IncomingDiff, want)
+				}
+				if gc.Failed && gc.IncomingDiff != 0 {
+					t.Errorf("marker %d was given a diff of %d", gc.ID, gc.IncomingDiff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type SeriesPoint struct {
+	Start        time.Time `json:"start"`
+	Entrances    int       `json:"entrances"`
+	Exits        int       `json:"exits"`
+	Interpolated bool      `json:"interpolated,omitempty"`
+	FailedPolls  int       `json:"failed_polls,omitempty"`
+}
+
+// maxInterpolate caps ?interpolate= so a request can't paper over a long
+// outage.
+const maxInterpolate = 24
+
+// seriesIntervals are the bucket sizes /series accepts.
+var seriesIntervals = map[string]bool{"hour": true, "day": true, "week": true, "month": true}
+
+// handleSeries buckets entrances and exits by ?interval=hour|day|week|month
+// (default day) over start_date/end_date (default the past week), with
+// boundaries drawn in ?tz= (default the app's zone). Buckets with
+// no readings are included as zeros so charts get a continuous series; buckets
+// that haven't started yet are left off. With ?interpolate=N, runs of up to N
+// empty buckets between two buckets with readings are filled in linearly and
+// flagged; longer runs stay zero so outages aren't disguised as traffic.
+func (app *App) handleSeries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "day"
+	}
+	if !seriesIntervals[interval] {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid interval %q: must be hour, day, week or month", interval))
+		return
+	}
+
+	interpolate := 0
+	if v := r.URL.Query().Get("interpolate"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxInterpolate {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("interpolate must be between 0 and %d", maxInterpolate))
+			return
+		}
+		interpolate = n
+	}
+
+	loc, err := tzParam(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 7, loc)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	// Group by poll timestamp and bucket in Go, which keeps the SQL the same
+	// for every interval and handles DST the same way the rest of the app does.
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0),
+			SUM(failed = 0), SUM(failed)
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ?`+filter+`
+		GROUP BY timestamp
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	// A bucket holding only failure markers has no data, so it is
+	// interpolated like a missing one but reports its failed polls.
+	type totals struct{ in, out, readings, failed int }
+	buckets := map[time.Time]totals{}
+	for rows.Next() {
+		var ts time.Time
+		var in, out, readings, failed int
+		if err := rows.Scan(&ts, &in, &out, &readings, &failed); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		b := bucketStart(ts.In(loc), interval)
+		t := buckets[b]
+		buckets[b] = totals{t.in + in, t.out + out, t.readings + readings, t.failed + failed}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := app.now().In(loc)
+	series := []SeriesPoint{}
+	present := []bool{}
+	for b := bucketStart(start, interval); b.Before(end) && !b.After(now); b = nextBucket(b, interval) {
+		t := buckets[b]
+		series = append(series, SeriesPoint{Start: b, Entrances: t.in, Exits: t.out, FailedPolls: t.failed})
+		present = append(present, t.readings > 0)
+	}
+	if interpolate > 0 {
+		interpolateGaps(series, present, interpolate)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"interval": interval,
+		"tz":       loc.String(),
+		"data":     series,
+	})
+}
+
+// bucketStart returns the start of the interval containing t, in t's
+// location. Weeks start on Monday.
+func bucketStart(t time.Time, interval string) time.Time {
+	y, m, d := t.Date()
+	switch interval {
+	case "hour":
+		return time.Date(y, m, d, t.Hour(), 0, 0, 0, t.Location())
+	case "week":
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+// nextBucket steps by wall clock so day, week and month buckets stay aligned
+// to midnight across DST changes.
+func nextBucket(b time.Time, interval string) time.Time {
+	switch interval {
+	case "hour":
+		return time.Date(b.Year(), b.Month(), b.Day(), b.Hour()+1, 0, 0, 0, b.Location())
+	case "week":
+		return b.AddDate(0, 0, 7)
+	case "month":
+		return b.AddDate(0, 1, 0)
+	default:
+		return b.AddDate(0, 0, 1)
+	}
+}
+
+// interpolateGaps fills runs of at most limit missing points that have a real
+// point on both sides with values on the straight line between them.
+func interpolateGaps(series []SeriesPoint, present []bool, limit int) {
+	last := -1
+	for i := range series {
+		if !present[i] {
+			continue
+		}
+		if gap := i - last - 1; last >= 0 && gap > 0 && gap <= limit {
+			from, to := series[last], series[i]
+			for j := last + 1; j < i; j++ {
+				f := float64(j-last) / float64(i-last)
+				series[j].Entrances = int(math.Round(float64(from.Entrances) + f*float64(to.Entrances-from.Entrances)))
+				series[j].Exits = int(math.Round(float64(from.Exits) + f*float64(to.Exits-from.Exits)))
+				series[j].Interpolated = true
+			}
+		}
+		last = i
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Sink is somewhere a new reading is stored. The database is always the first
+// and primary sink, and sets the reading's ID for the best effort backups
+// that follow it.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, gc *GateCount) error
+}
+
+type dbSink struct {
+	app *App
+}
+
+func (s *dbSink) Name() string { return "database" }
+
+func (s *dbSink) Write(ctx context.Context, gc *GateCount) error {
+	id, err := s.app.insertCount(ctx, *gc)
+	if err != nil {
+		return err
+	}
+	gc.ID = id
+	return nil
+}
+
+// fileSink appends readings as JSON lines to one file per local day, e.g.
+// gate_counts_2025-09-01.jsonl, so files rotate daily.
+type fileSink struct {
+	dir string
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+}
+
+func newFileSink(dir string) (*fileSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory: %w", err)
+	}
+	return &fileSink{dir: dir}, nil
+}
+
+func (s *fileSink) Name() string { return "file" }
+
+func (s *fileSink) Write(_ context.Context, gc *GateCount) error {
+	line, err := json.Marshal(gc)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := gc.Timestamp.Format(dateLayout)
+	if s.file == nil || s.day != day {
+		if s.file != nil {
+			_ = s.file.Close()
+		}
+		path := filepath.Join(s.dir, "gate_counts_"+day+".jsonl")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			s.file = nil
+			return err
+		}
+		s.file, s.day = f, day
+	}
+
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSinkRecordsInsertedID(t *testing.T) {
+	d := &fakeDB{}
+	app := &App{db: d.open(t), table: "lib_gate_counts"}
+	fs, err := newFileSink(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.file.Close()
+
+	tests := []struct {
+		name   string
+		gate   string
+		wantID int64
+	}{
+		{name: "first reading", gate: "Gate 1", wantID: 1},
+		{name: "second reading", gate: "Gate 2", wantID: 2},
+	}
+	ts := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gc := GateCount{Timestamp: ts, GateName: tt.gate, Status: statusOK}
+			for _, sink := range []Sink{&dbSink{app: app}, fs} {
+				if err := sink.Write(context.Background(), &gc); err != nil {
+					t.Fatalf("%s: %v", sink.Name(), err)
+				}
+			}
+			if gc.ID != tt.wantID {
+				t.Errorf("ID = %d, want %d", gc.ID, tt.wantID)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(filepath.Join(fs.dir, "gate_counts_"+ts.Format(dateLayout)+".jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for _, tt := range tests {
+		var got GateCount
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.GateName != tt.gate || got.ID != tt.wantID {
+			t.Errorf("file line = %s id %d, want %s id %d", got.GateName, got.ID, tt.gate, tt.wantID)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type RangeStats struct {
+	TotalEntrances int `json:"total_entrances"`
+	TotalExits     int `json:"total_exits"`
+	TotalAlarms    int `json:"total_alarms"`
+}
+
+// handleRangeStats returns just the summed positive diffs for the same
+// gate_name/fuzzy/start_date/end_date filters /query accepts, along with the
+// exclude_suspect, building and status filters of the other stats endpoints.
+func (app *App) handleRangeStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter := filterFromQuery(r)
+	if err := app.validateFilter(&filter); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// statsFilter adds the building condition with the other stats filters
+	filter.Building = ""
+	where, args := filter.Build()
+	extra, extraArgs := statsFilter(r)
+	where += extra
+	args = append(args, extraArgs...)
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	if app.notModified(ctx, w, r, where, args) {
+		return
+	}
+
+	var stats RangeStats
+	err := app.db.QueryRowContext(ctx, `
+		SELECT
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0)
+		FROM `+app.table+where, args...).Scan(&stats.TotalEntrances, &stats.TotalExits, &stats.TotalAlarms)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    stats,
+	})
+}
+
+type GateBalance struct {
+	GateName       string  `json:"gate_name"`
+	Entrances      int     `json:"entrances"`
+	Exits          int     `json:"exits"`
+	Difference     int     `json:"difference"`
+	ImbalancePct   float64 `json:"imbalance_pct"`
+	ExceedsBalance bool    `json:"flagged"`
+}
+
+// handleBalance compares summed entrances and exits per gate over a range
+// (default the past week). Over days these should roughly cancel out, so a
+// gate whose difference exceeds the threshold percentage of its traffic
+// likely has a failing directional sensor.
+func (app *App) handleBalance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 7, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	threshold := app.balanceThresholdPct
+	if v := r.URL.Query().Get("threshold_pct"); v != "" {
+		threshold, err = strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 {
+			writeError(w, http.StatusBadRequest, "threshold_pct must be a non-negative number")
+			return
+		}
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT gate_name,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0)
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ?`+filter+`
+		GROUP BY gate_name
+		ORDER BY gate_name
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	results := []GateBalance{}
+	for rows.Next() {
+		var b GateBalance
+		if err := rows.Scan(&b.GateName, &b.Entrances, &b.Exits); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		b.Difference = b.Entrances - b.Exits
+		if busiest := max(b.Entrances, b.Exits); busiest > 0 {
+			b.ImbalancePct = float64(abs(b.Difference)) / float64(busiest) * 100
+		}
+		b.ExceedsBalance = b.ImbalancePct > threshold
+		b.ImbalancePct = app.round(b.ImbalancePct)
+		results = append(results, b)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"start":         start,
+		"end":           end,
+		"threshold_pct": threshold,
+		"data":          results,
+	})
+}
+
+// round rounds a computed average or percentage to DECIMAL_PLACES so JSON
+// output is ready to display. A negative setting leaves values unrounded.
+func (app *App) round(v float64) float64 {
+	if app.decimalPlaces < 0 {
+		return v
+	}
+	scale := math.Pow10(app.decimalPlaces)
+	return math.Round(v*scale) / scale
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type DayTotal struct {
+	Date      string `json:"date"`
+	Entrances int    `json:"entrances"`
+}
+
+// handleBusiestDay finds the day with the most entrances over start_date and
+// end_date (default the past year), optionally for one gate_name, along with
+// the runner-up. Ties go to the earlier day.
+func (app *App) handleBusiestDay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 365, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where := " WHERE timestamp >= ? AND timestamp < ?"
+	args := []any{start, end}
+	gateName := r.URL.Query().Get("gate_name")
+	if gateName != "" {
+		where += " AND gate_name = ?"
+		args = append(args, gateName)
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(`+app.localTimestamp()+`, '%Y-%m-%d') AS day,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0) AS entrances
+		FROM `+app.table+where+filter+`
+		GROUP BY day
+		ORDER BY entrances DESC, day ASC
+		LIMIT 2
+	`, append(args, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	var days []DayTotal
+	for rows.Next() {
+		var d DayTotal
+		if err := rows.Scan(&d.Date, &d.Entrances); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		days = append(days, d)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"start":     start,
+		"end":       end,
+		"busiest":   nil,
+		"runner_up": nil,
+	}
+	if gateName != "" {
+		response["gate_name"] = gateName
+	}
+	if len(days) > 0 {
+		response["busiest"] = days[0]
+	}
+	if len(days) > 1 {
+		response["runner_up"] = days[1]
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type GateSummary struct {
+	GateName     string `json:"gate_name,omitempty"`
+	Building     string `json:"building,omitempty"`
+	Entrances    int    `json:"entrances"`
+	Exits        int    `json:"exits"`
+	Alarms       int    `json:"alarms"`
+	DaysWithData int    `json:"days_with_data"`
+}
+
+// handleGateSummary returns one row per gate of totals over start_date and
+// end_date (default the past week) for side-by-side reports. With
+// group_by=building the rows are per building instead.
+func (app *App) handleGateSummary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groupBy := r.URL.Query().Get("group_by")
+	switch groupBy {
+	case "", "gate":
+		groupBy = "gate_name"
+	case "building":
+	default:
+		writeError(w, http.StatusBadRequest, `group_by must be "gate" or "building"`)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 7, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT `+groupBy+`,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END), 0),
+			COUNT(DISTINCT DATE(`+app.localTimestamp()+`))
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ? AND gate_name IS NOT NULL`+filter+`
+		GROUP BY `+groupBy+`
+		ORDER BY `+groupBy+`
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	results := []GateSummary{}
+	for rows.Next() {
+		var g GateSummary
+		key := &g.GateName
+		if groupBy == "building" {
+			key = &g.Building
+		}
+		if err := rows.Scan(key, &g.Entrances, &g.Exits, &g.Alarms, &g.DaysWithData); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		results = append(results, g)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"start":   start,
+		"end":     end,
+		"data":    results,
+	})
+}
+
+type GateShare struct {
+	GateName  string  `json:"gate_name"`
+	Building  string  `json:"building"`
+	Entrances int     `json:"entrances"`
+	Percent   float64 `json:"percent"`
+}
+
+// handleGateShare returns each gate's entrances over start_date and end_date
+// (default the past 30 days) and its percentage of its own building's total,
+// busiest gate first, to show which entrance carries the most load. With
+// several buildings the shares of each building add up to 100 on their own.
+func (app *App) handleGateShare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 30, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT gate_name, building, COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0) AS entrances
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ? AND gate_name IS NOT NULL`+filter+`
+		GROUP BY gate_name, building
+		ORDER BY entrances DESC, gate_name
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	shares := []GateShare{}
+	total := 0
+	buildingTotals := map[string]int{}
+	for rows.Next() {
+		var s GateShare
+		if err := rows.Scan(&s.GateName, &s.Building, &s.Entrances); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		total += s.Entrances
+		buildingTotals[s.Building] += s.Entrances
+		shares = append(shares, s)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for i := range shares {
+		if t := buildingTotals[shares[i].Building]; t > 0 {
+			shares[i].Percent = app.round(float64(shares[i].Entrances) / float64(t) * 100)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":         true,
+		"start":           start,
+		"end":             end,
+		"total_entrances": total,
+		"data":            shares,
+	})
+}
+
+type CumulativeDay struct {
+	Date       string `json:"date"`
+	Entrances  int    `json:"entrances"`
+	Cumulative int    `json:"cumulative"`
+}
+
+// handleCumulative returns a running entrance total for each day over
+// start_date and end_date (default the past year), optionally for one
+// gate_name, for tracking progress toward a goal. The database only groups by
+// day; the running sum is taken here so it doesn't rely on window functions.
+// Days without readings repeat the previous total.
+func (app *App) handleCumulative(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 365, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	where := " WHERE timestamp >= ? AND timestamp < ?"
+	args := []any{start, end}
+	gateName := r.URL.Query().Get("gate_name")
+	if gateName != "" {
+		where += " AND gate_name = ?"
+		args = append(args, gateName)
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(`+app.localTimestamp()+`, '%Y-%m-%d') AS day,
+			COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0) AS entrances
+		FROM `+app.table+where+filter+`
+		GROUP BY day
+		ORDER BY day
+	`, append(args, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	entrances := map[string]int{}
+	for rows.Next() {
+		var day string
+		var n int
+		if err := rows.Scan(&day, &n); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		entrances[day] = n
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := app.now()
+	results := []CumulativeDay{}
+	total := 0
+	for d := start; d.Before(end) && !d.After(now); d = d.AddDate(0, 0, 1) {
+		day := d.Format(dateLayout)
+		total += entrances[day]
+		results = append(results, CumulativeDay{Date: day, Entrances: entrances[day], Cumulative: total})
+	}
+
+	response := map[string]interface{}{
+		"success":         true,
+		"start":           start,
+		"end":             end,
+		"total_entrances": total,
+		"data":            results,
+	}
+	if gateName != "" {
+		response["gate_name"] = gateName
+	}
+	writeJSON(w, http.StatusOK, response)
+}
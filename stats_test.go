@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGateSharePerBuilding(t *testing.T) {
+	d := &fakeDB{query: func(string, []driver.NamedValue) (fakeResult, error) {
+		return fakeResult{columns: []string{"gate_name", "building", "entrances"}, rows: [][]driver.Value{
+			{"Linderman/Gate 1", "Linderman", int64(300)},
+			{"Fairchild/Gate 1", "Fairchild", int64(200)},
+			{"Linderman/Gate 2", "Linderman", int64(100)},
+			{"Fairchild/Gate 2", "Fairchild", int64(0)},
+		}}, nil
+	}}
+	app := &App{db: d.open(t), table: "lib_gate_counts", now: time.Now, queryTimeout: time.Minute, decimalPlaces: 2}
+
+	rr := httptest.NewRecorder()
+	app.handleGateShare(rr, httptest.NewRequest(http.MethodGet, "/gate_share", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		TotalEntrances int         `json:"total_entrances"`
+		Data           []GateShare `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]float64{
+		"Linderman/Gate 1": 75,
+		"Linderman/Gate 2": 25,
+		"Fairchild/Gate 1": 100,
+		"Fairchild/Gate 2": 0,
+	}
+	if resp.TotalEntrances != 600 {
+		t.Errorf("total_entrances = %d, want 600", resp.TotalEntrances)
+	}
+	for _, s := range resp.Data {
+		if s.Percent != want[s.GateName] {
+			t.Errorf("%s percent = %v, want %v", s.GateName, s.Percent, want[s.GateName])
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+const defaultTimezone = "America/New_York"
+
+// validTimezone restricts TZ to IANA-style names so it can be spliced into
+// CONVERT_TZ.
+var validTimezone = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+/-]*$`)
+
+// localTimestamp returns the SQL expression for a reading's timestamp in the
+// display time zone, for use inside DATE(), HOUR() and the like. Readings are
+// stored in local time unless STORE_UTC is set, in which case they are
+// converted on read. Range comparisons on the bare column stay index-friendly
+// either way because the driver converts bound times to the storage zone.
+func (app *App) localTimestamp() string {
+	if !app.storeUTC {
+		return "timestamp"
+	}
+	return "CONVERT_TZ(timestamp, '+00:00', '" + app.timezone + "')"
+}
+
+// checkTimezoneTables makes sure MariaDB can convert readings into tz.
+// CONVERT_TZ returns NULL for a named zone when the time zone tables aren't
+// loaded, which would otherwise surface later as failed or empty stats.
+func checkTimezoneTables(db *sql.DB, tz string) error {
+	var converted sql.NullTime
+	if err := db.QueryRow("SELECT CONVERT_TZ(NOW(), '+00:00', ?)", tz).Scan(&converted); err != nil {
+		return fmt.Errorf("failed to check time zone support for STORE_UTC: %w", err)
+	}
+	if !converted.Valid {
+		return fmt.Errorf("STORE_UTC needs the MariaDB time zone tables to convert to TZ %q; load them with mysql_tzinfo_to_sql /usr/share/zoneinfo | mariadb mysql", tz)
+	}
+	return nil
+}
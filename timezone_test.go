@@ -0,0 +1,29 @@
+package main
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+)
+
+func TestCheckTimezoneTables(t *testing.T) {
+	tests := []struct {
+		name      string
+		converted driver.Value
+		wantErr   bool
+	}{
+		{name: "tables loaded", converted: time.Now()},
+		{name: "tables missing", converted: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &fakeDB{query: func(string, []driver.NamedValue) (fakeResult, error) {
+				return fakeResult{columns: []string{"converted"}, rows: [][]driver.Value{{tt.converted}}}, nil
+			}}
+			err := checkTimezoneTables(d.open(t), "America/New_York")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkTimezoneTables() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type RunningTotal struct {
+	GateName  string     `json:"gate_name"`
+	Entrances int64      `json:"entrances"`
+	Exits     int64      `json:"exits"`
+	Alarms    int64      `json:"alarms"`
+	Net       int64      `json:"net"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// totalsSink keeps each gate's all-time totals in the totals table as
+// readings arrive. Because the checkpoint lives outside the counts table it
+// survives old rows being purged, so long-running occupancy doesn't reset
+// with them.
+type totalsSink struct {
+	app *App
+
+	// stale holds gates whose last checkpoint write failed. Their next write
+	// recomputes the checkpoint instead of adding to one that's missing a
+	// reading.
+	mu    sync.Mutex
+	stale map[string]bool
+}
+
+func (s *totalsSink) Name() string { return "running totals" }
+
+func (s *totalsSink) Write(ctx context.Context, gc *GateCount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.stale[gc.GateName] {
+		err = s.app.resetRunningTotal(ctx, s.app.db, gc.GateName)
+	} else {
+		_, err = s.app.db.ExecContext(ctx, `
+			INSERT INTO `+companionTable(s.app.table, "totals")+` (gate_name, entrances, exits, alarms, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON DUPLICATE KEY UPDATE
+				entrances = entrances + VALUES(entrances),
+				exits = exits + VALUES(exits),
+				alarms = alarms + VALUES(alarms),
+				updated_at = VALUES(updated_at)
+		`, gc.GateName, max(gc.IncomingDiff, 0), max(gc.OutgoingDiff, 0), max(gc.AlarmDiff, 0), gc.Timestamp)
+	}
+
+	if s.stale == nil {
+		s.stale = map[string]bool{}
+	}
+	if err != nil {
+		s.stale[gc.GateName] = true
+		return fmt.Errorf("running total for %s is stale until its next write: %w", gc.GateName, err)
+	}
+	delete(s.stale, gc.GateName)
+	return nil
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// resetRunningTotal recomputes a gate's checkpoint from its baseline and
+// readings. Any change to stored readings other than a new one (an edit,
+// delete, backfill or rederive) calls this in its transaction, since adding
+// that reading's diffs is all the sink itself does.
+func (app *App) resetRunningTotal(ctx context.Context, db execer, gateName string) error {
+	if !app.runningTotals {
+		return nil
+	}
+	totals := companionTable(app.table, "totals")
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+totals+" WHERE gate_name = ?", gateName); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO `+totals+` (gate_name, entrances, exits, alarms, updated_at)
+	`+allTimeTotals(app.table, " AND gate_name = ?"), gateName, gateName)
+	return err
+}
+
+// allTimeTotals selects each gate's all-time totals as the carry-forward
+// baseline left by purged readings plus the readings still recorded. filter
+// is added to the conditions on both, and so takes its arguments twice.
+func allTimeTotals(table, filter string) string {
+	return `
+		SELECT gate_name, SUM(entrances), SUM(exits), SUM(alarms), MAX(updated_at)
+		FROM (
+			SELECT gate_name,
+				CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END AS entrances,
+				CASE WHEN outgoing_diff > 0 THEN outgoing_diff ELSE 0 END AS exits,
+				CASE WHEN alarm_diff > 0 THEN alarm_diff ELSE 0 END AS alarms,
+				timestamp AS updated_at
+			FROM ` + table + `
+			WHERE gate_name IS NOT NULL` + filter + `
+			UNION ALL
+			SELECT gate_name, entrances, exits, alarms, through
+			FROM ` + companionTable(table, "baseline") + `
+			WHERE gate_name IS NOT NULL` + filter + `
+		) readings
+		GROUP BY gate_name
+	`
+}
+
+// seedRunningTotals rebuilds every gate's checkpoint at startup from the
+// baseline and readings already recorded, which also repairs any left stale
+// by a failed write before a restart.
+func seedRunningTotals(db *sql.DB, table string) error {
+	_, err := db.Exec(`
+		REPLACE INTO ` + companionTable(table, "totals") + ` (gate_name, entrances, exits, alarms, updated_at)
+	` + allTimeTotals(table, ""))
+	return err
+}
+
+// handleRunningTotals reports each gate's all-time totals, read from the
+// checkpoints when RUNNING_TOTALS is enabled and otherwise computed from the
+// purge baseline and remaining readings.
+func (app *App) handleRunningTotals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	query := `
+		SELECT gate_name, entrances, exits, alarms, updated_at
+		FROM ` + companionTable(app.table, "totals") + `
+	`
+	if !app.runningTotals {
+		query = allTimeTotals(app.table, "")
+	}
+	rows, err := app.db.QueryContext(ctx, query+" ORDER BY gate_name")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	totals := []RunningTotal{}
+	for rows.Next() {
+		var t RunningTotal
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&t.GateName, &t.Entrances, &t.Exits, &t.Alarms, &updatedAt); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if updatedAt.Valid {
+			t.UpdatedAt = &updatedAt.Time
+		}
+		t.Net = t.Entrances - t.Exits
+		totals = append(totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    totals,
+	})
+}
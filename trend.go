@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type HourlyTrend struct {
+	WindowStart      time.Time `json:"window_start"`
+	WindowEnd        time.Time `json:"window_end"`
+	Entrances        int       `json:"entrances"`
+	Typical          *float64  `json:"typical"`
+	Samples          int       `json:"samples"`
+	PercentVsTypical *float64  `json:"percent_vs_typical"`
+	Status           string    `json:"status"`
+	InsufficientData bool      `json:"insufficient_data"`
+}
+
+// handleTrend compares entrances in the most recently completed hour with the
+// average for the same hour of the week over the preceding weeks.
+//
+// Readings are recorded at the top of the hour and cover the hour before, so
+// the hour in progress has no data yet. The comparison always uses the latest
+// complete hour, falling back one more hour if this hour's poll hasn't landed.
+// With fewer than MIN_AVERAGE_SAMPLES past weeks of data the typical value is
+// null and insufficient_data is set instead.
+func (app *App) handleTrend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	weeks := 8
+	if v := r.URL.Query().Get("weeks"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 52 {
+			writeError(w, http.StatusBadRequest, "weeks must be between 1 and 52")
+			return
+		}
+		weeks = n
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	filter, filterArgs := statsFilter(r)
+	hour := app.now().Truncate(time.Hour)
+	entrances, found, err := app.entrancesInPollHour(ctx, hour, filter, filterArgs)
+	if err == nil && !found {
+		hour = hour.Add(-time.Hour)
+		entrances, _, err = app.entrancesInPollHour(ctx, hour, filter, filterArgs)
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	local := app.localTimestamp()
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT DATE_FORMAT(`+local+`, '%Y-%m-%d') AS day, SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END)
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ? AND DAYOFWEEK(`+local+`) = ? AND HOUR(`+local+`) = ?`+filter+`
+		GROUP BY day
+	`, append([]any{hour.AddDate(0, 0, -7*weeks), hour, int(hour.Weekday()) + 1, hour.Hour()}, filterArgs...)...)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	sums := map[string]int{}
+	for rows.Next() {
+		var day string
+		var sum int
+		if err := rows.Scan(&day, &sum); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sums[day] = sum
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// With SKIP_UNCHANGED_READINGS a quiet hour leaves no row, only an
+	// earlier reading's polled_through, and still counts as a zero sample
+	skipped, err := app.skippedPollSpans(ctx, hour.AddDate(0, 0, -7*weeks), hour, filter, filterArgs)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	total, samples := 0, 0
+	for week := 1; week <= weeks; week++ {
+		past := hour.AddDate(0, 0, -7*week)
+		if sum, ok := sums[past.Format(dateLayout)]; ok {
+			total += sum
+			samples++
+		} else if skipped.covers(past, past.Add(time.Hour)) {
+			samples++
+		}
+	}
+
+	trend := HourlyTrend{
+		WindowStart: hour.Add(-time.Hour),
+		WindowEnd:   hour,
+		Entrances:   entrances,
+		Samples:     samples,
+		Status:      "unknown",
+	}
+	trend.InsufficientData = samples < max(app.minAverageSamples, 1)
+	if !trend.InsufficientData {
+		typical := float64(total) / float64(samples)
+		roundedTypical := app.round(typical)
+		trend.Typical = &roundedTypical
+		if typical > 0 {
+			pct := (float64(entrances) - typical) / typical * 100
+			roundedPct := app.round(pct)
+			trend.PercentVsTypical = &roundedPct
+			switch {
+			case pct > 0:
+				trend.Status = "ahead"
+			case pct < 0:
+				trend.Status = "behind"
+			default:
+				trend.Status = "even"
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"data":    trend,
+	})
+}
+
+// entrancesInPollHour sums entrances recorded by the poll that ran at the
+// given top of the hour and reports whether any gate had recorded yet.
+func (app *App) entrancesInPollHour(ctx context.Context, hour time.Time, filter string, filterArgs []any) (int, bool, error) {
+	var count, entrances int
+	err := app.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN incoming_diff > 0 THEN incoming_diff ELSE 0 END), 0)
+		FROM `+app.table+`
+		WHERE timestamp >= ? AND timestamp < ?`+filter+`
+	`, append([]any{hour, hour.Add(time.Hour)}, filterArgs...)...).Scan(&count, &entrances)
+	return entrances, count > 0, err
+}
+
+// pollSpan is a reading and the unchanged polls after it that
+// SKIP_UNCHANGED_READINGS left out of the table.
+type pollSpan struct {
+	from, through time.Time
+}
+
+type pollSpans []pollSpan
+
+// covers reports whether a skipped poll could have fallen in [start, end).
+func (ps pollSpans) covers(start, end time.Time) bool {
+	for _, p := range ps {
+		if !p.through.Before(start) && p.from.Before(end) {
+			return true
+		}
+	}
+	return false
+}
+
+// skippedPollSpans returns the readings within [start, end) that stand for
+// skipped unchanged polls.
+func (app *App) skippedPollSpans(ctx context.Context, start, end time.Time, filter string, filterArgs []any) (pollSpans, error) {
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, polled_through
+		FROM `+app.table+`
+		WHERE polled_through >= ? AND timestamp < ?`+filter+`
+	`, append([]any{start, end}, filterArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var spans pollSpans
+	for rows.Next() {
+		var p pollSpan
+		if err := rows.Scan(&p.from, &p.through); err != nil {
+			return nil, err
+		}
+		spans = append(spans, p)
+	}
+	return spans, rows.Err()
+}
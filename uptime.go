@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// closure is a known period, such as a holiday, when gates are not expected
+// to report.
+type closure struct {
+	start time.Time
+	end   time.Time
+}
+
+type closures []closure
+
+// parseClosures reads a comma separated list of dates (2025-12-25) or
+// inclusive date ranges (2025-12-24..2026-01-01).
+func parseClosures(s string) (closures, error) {
+	var cs closures
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, "..")
+		if !ok {
+			to = from
+		}
+		start, err := time.ParseInLocation(dateLayout, strings.TrimSpace(from), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid closure %q: %w", part, err)
+		}
+		end, err := time.ParseInLocation(dateLayout, strings.TrimSpace(to), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("invalid closure %q: %w", part, err)
+		}
+		if end.Before(start) {
+			return nil, fmt.Errorf("invalid closure %q: end before start", part)
+		}
+		cs = append(cs, closure{start: start, end: end.AddDate(0, 0, 1)})
+	}
+	return cs, nil
+}
+
+func (cs closures) contains(t time.Time) bool {
+	for _, c := range cs {
+		if !t.Before(c.start) && t.Before(c.end) {
+			return true
+		}
+	}
+	return false
+}
+
+type GateUptime struct {
+	GateName     string  `json:"gate_name"`
+	Expected     int     `json:"expected"`
+	Recorded     int     `json:"recorded"`
+	Availability float64 `json:"availability"`
+}
+
+// handleUptime reports, per gate, the fraction of expected polls in a range
+// that produced a reading. Polls falling in configured closures are not
+// expected and readings taken during them are not counted.
+func (app *App) handleUptime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 30, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if now := app.now(); end.After(now) {
+		end = now
+	}
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	gates, err := app.listGates(ctx)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// A reading standing in for skipped unchanged polls started before the
+	// range can still cover slots in it
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT gate_name, timestamp, polled_through FROM `+app.table+`
+		WHERE (timestamp >= ? OR polled_through >= ?) AND timestamp < ? AND failed = 0
+	`, start, start, end)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	// A gate can have more than one row in a slot (e.g. a restart mid-hour),
+	// so count distinct poll slots rather than rows.
+	slots := map[string]map[time.Time]bool{}
+	for rows.Next() {
+		var gateName string
+		var ts time.Time
+		var through sql.NullTime
+		if err := rows.Scan(&gateName, &ts, &through); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if slots[gateName] == nil {
+			slots[gateName] = map[time.Time]bool{}
+		}
+		for _, slot := range polledSlots(ts, through, app.gateInterval(gateName), start, end) {
+			if !app.closures.contains(slot) {
+				slots[gateName][slot] = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	results := []GateUptime{}
+	for _, g := range gates {
+		expected := app.expectedPolls(start, end, app.gateInterval(g.Name))
+		u := GateUptime{
+			GateName: g.Name,
+			Expected: expected,
+			Recorded: min(len(slots[g.Name]), expected),
+		}
+		if expected > 0 {
+			// A fraction, so DECIMAL_PLACES would turn 0.996 into full uptime
+			u.Availability = float64(u.Recorded) / float64(expected)
+		}
+		results = append(results, u)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"start":         start,
+		"end":           end,
+		"poll_interval": app.pollInterval.String(),
+		"data":          results,
+	})
+}
+
+// expectedPolls counts the poll slots of interval in [start, end) that fall
+// outside configured closures.
+func (app *App) expectedPolls(start, end time.Time, interval time.Duration) int {
+	expected := 0
+	for slot := start.Truncate(interval); slot.Before(end); slot = slot.Add(interval) {
+		if !slot.Before(start) && !app.closures.contains(slot) {
+			expected++
+		}
+	}
+	return expected
+}
+
+// polledSlots lists the poll slots in [start, end) a reading taken at ts
+// stands for: its own, and with SKIP_UNCHANGED_READINGS every slot up to the
+// last unchanged poll recorded in through.
+func polledSlots(ts time.Time, through sql.NullTime, interval time.Duration, start, end time.Time) []time.Time {
+	last := ts
+	if through.Valid && through.Time.After(ts) {
+		last = through.Time
+	}
+	var slots []time.Time
+	for slot := ts.Truncate(interval); !slot.After(last) && slot.Before(end); slot = slot.Add(interval) {
+		if !slot.Before(start.Truncate(interval)) {
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+type Gap struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	MissedPolls int       `json:"missed_polls"`
+	FailedPolls int       `json:"failed_polls"`
+}
+
+// handleGaps lists the runs of consecutive expected polls a gate has no
+// reading for over start_date/end_date (default the past week). Each gap runs
+// from the first missed poll slot to the end of the last. Closures aren't
+// expected polls, so they end a gap rather than extend it. With
+// FAILURE_MARKERS set, failed_polls counts the missed slots the gate was
+// polled but failed in, telling an outage apart from polls that never ran.
+func (app *App) handleGaps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	gateName := r.URL.Query().Get("gate_name")
+	if gateName == "" {
+		writeError(w, http.StatusBadRequest, "gate_name is required")
+		return
+	}
+
+	start, end, err := app.rangeParams(r, 7, time.Local)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if now := app.now(); end.After(now) {
+		end = now
+	}
+
+	interval := app.gateInterval(gateName)
+
+	ctx, cancel := app.queryContext(r)
+	defer cancel()
+
+	rows, err := app.db.QueryContext(ctx, `
+		SELECT timestamp, failed, polled_through FROM `+app.table+`
+		WHERE gate_name = ? AND (timestamp >= ? OR polled_through >= ?) AND timestamp < ?
+	`, gateName, start, start, end)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	recorded, failed := map[time.Time]bool{}, map[time.Time]bool{}
+	for rows.Next() {
+		var ts time.Time
+		var marker bool
+		var through sql.NullTime
+		if err := rows.Scan(&ts, &marker, &through); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if marker {
+			failed[ts.Truncate(interval)] = true
+			continue
+		}
+		for _, slot := range polledSlots(ts, through, interval, start, end) {
+			recorded[slot] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	gaps := []Gap{}
+	var current *Gap
+	missed := 0
+	for slot := start.Truncate(interval); slot.Before(end); slot = slot.Add(interval) {
+		if slot.Before(start) {
+			continue
+		}
+		if recorded[slot] || app.closures.contains(slot) {
+			current = nil
+			continue
+		}
+		missed++
+		if current == nil {
+			gaps = append(gaps, Gap{Start: slot})
+			current = &gaps[len(gaps)-1]
+		}
+		current.End = slot.Add(interval)
+		current.MissedPolls++
+		if failed[slot] {
+			current.FailedPolls++
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":       true,
+		"gate_name":     gateName,
+		"poll_interval": interval.String(),
+		"missed_polls":  missed,
+		"data":          gaps,
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestPolledSlots(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2026, 3, 2, h, m, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		ts         time.Time
+		through    sql.NullTime
+		start, end time.Time
+		want       int
+	}{
+		{name: "single reading", ts: at(9, 0), start: at(0, 0), end: at(23, 0), want: 1},
+		{name: "skipped polls", ts: at(1, 0), through: sql.NullTime{Time: at(6, 1), Valid: true}, start: at(0, 0), end: at(23, 0), want: 6},
+		{name: "starts before the range", ts: at(1, 0), through: sql.NullTime{Time: at(6, 1), Valid: true}, start: at(4, 0), end: at(23, 0), want: 3},
+		{name: "runs past the range", ts: at(1, 0), through: sql.NullTime{Time: at(6, 1), Valid: true}, start: at(0, 0), end: at(3, 0), want: 2},
+		{name: "through before the reading", ts: at(5, 0), through: sql.NullTime{Time: at(4, 0), Valid: true}, start: at(0, 0), end: at(23, 0), want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := polledSlots(tt.ts, tt.through, time.Hour, tt.start, tt.end); len(got) != tt.want {
+				t.Errorf("polledSlots() = %v, want %d slots", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPollSpansCovers(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 3, 2, h, 0, 0, 0, time.UTC) }
+	spans := pollSpans{{from: at(1), through: at(6).Add(time.Minute)}}
+	tests := []struct {
+		hour int
+		want bool
+	}{
+		{0, false},
+		{3, true},
+		{6, true},
+		{7, false},
+	}
+	for _, tt := range tests {
+		if got := spans.covers(at(tt.hour), at(tt.hour+1)); got != tt.want {
+			t.Errorf("covers(%02d:00) = %v, want %v", tt.hour, got, tt.want)
+		}
+	}
+}